@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,9 +19,16 @@ import (
 )
 
 const (
-	retryInterval = 2 * time.Second
+	retryInterval        = 2 * time.Second
+	defaultInformTimeout = 5 * time.Second
+	defaultQueueSize     = 256
+	defaultFanOut        = 4
+	defaultTrapPort      = 162
+	// interval between the attempts to restart a failed subscription
+	subscriptionRetryInterval = 10 * time.Second
 )
 const (
+	snmpTrapsPath            = ".system.snmp-traps"
 	snmpTrapsDestinationPath = ".system.snmp-traps.destination"
 	gnmiServerUnixSocket     = "unix:///opt/srlinux/var/run/sr_gnmi_server"
 )
@@ -33,9 +41,40 @@ type app struct {
 	agent *agent.Agent
 	tuCh  chan *telemUpdate
 
-	tg        *target.Target
-	traps     []*trapDefinition
+	tg *target.Target
+	// answers the tasks gNMI Get requests
+	getter gnmiGetter
+	// reports the steps of the trap definitions evaluation, if set
+	trace traceFunc
+	// running trap definitions, swapped on reload
+	trapsM *sync.RWMutex
+	traps  []*trapDefinition
+	// trap definitions loaded from the trap directory
+	fileTraps []*trapDefinition
+	// compiled configured trap definitions by name
+	cfgTraps map[string]*trapDefinition
+	// configured trap definitions compilation errors by name
+	cfgTrapErrs map[string]error
+	// names of the trap definitions with admin-state disable
+	disabledTraps map[string]struct{}
+	// names of the trap definitions with published state
+	trapDefsTelem map[string]struct{}
+	// trap definition files load status by path
+	trapFiles map[string]*trapFileStatus
+	// per trigger path gNMI subscriptions
+	subs      *subscriptions
 	startTime time.Time
+	// sysUpTime.0 source
+	uptimeSource string
+	bootTime     *bootTime
+	// SNMPv3 local engine
+	engine *snmpEngine
+	// global variables, available to all trap definitions
+	globals *globals
+	// triggers state per path instance
+	triggerStates *triggerStates
+	// matched events waiting to be rendered, see enqueueRender
+	renderCh chan *renderJob
 }
 
 type appOption func(*app)
@@ -58,31 +97,53 @@ func WithTrapDir(dir string) func(a *app) {
 	}
 }
 
+func WithUptimeSource(src string) func(a *app) {
+	return func(a *app) {
+		a.uptimeSource = src
+	}
+}
+
 func New(opts ...appOption) *app {
 	a := &app{
 		config: &config{
 			m:            &sync.RWMutex{},
 			destinations: map[string]*snmpTrapDestination{},
+			senders:      map[string]*sender{},
+			fanOut:       newFanOut(defaultFanOut),
 			trx:          map[string][]*ndk.ConfigNotification{},
 			nwInst:       map[string]*ndk.NetworkInstanceData{},
+
+			trapDefinitions: map[string]*trapDefinitionConfig{},
+		},
+		debug:  false,
+		agent:  &agent.Agent{},
+		tuCh:   make(chan *telemUpdate),
+		tg:     &target.Target{},
+		trapsM: &sync.RWMutex{},
+		traps:  make([]*trapDefinition, 0),
+
+		cfgTraps:      map[string]*trapDefinition{},
+		cfgTrapErrs:   map[string]error{},
+		disabledTraps: map[string]struct{}{},
+		trapDefsTelem: map[string]struct{}{},
+		subs: &subscriptions{
+			m:         &sync.Mutex{},
+			cancelFns: map[string]context.CancelFunc{},
 		},
-		debug:     false,
-		agent:     &agent.Agent{},
-		tuCh:      make(chan *telemUpdate),
-		tg:        &target.Target{},
-		traps:     make([]*trapDefinition, 0),
-		startTime: time.Now(),
+		startTime:     time.Now(),
+		bootTime:      &bootTime{m: &sync.RWMutex{}},
+		engine:        newSNMPEngine(),
+		globals:       newGlobals(),
+		triggerStates: newTriggerStates(),
+		renderCh:      make(chan *renderJob, renderQueueSize),
 	}
 	for _, opt := range opts {
 		opt(a)
 	}
-READ:
 	// walk trap dir
-	err := a.readTrapsDefinition()
+	_, err := a.loadTraps()
 	if err != nil {
 		log.Errorf("failed to read trap definitions: %v", err)
-		time.Sleep(10 * time.Second)
-		goto READ
 	}
 	log.Infof("read %d trap definition(s)", len(a.traps))
 	return a
@@ -91,18 +152,51 @@ READ:
 type config struct {
 	m            *sync.RWMutex
 	destinations map[string]*snmpTrapDestination
-	trx          map[string][]*ndk.ConfigNotification
-	nwInst       map[string]*ndk.NetworkInstanceData
+	senders      map[string]*sender
+	// limits the number of destinations sending concurrently
+	fanOut *fanOut
+	trx    map[string][]*ndk.ConfigNotification
+	nwInst map[string]*ndk.NetworkInstanceData
+	// configured trap definitions by name
+	trapDefinitions map[string]*trapDefinitionConfig
 }
 
 type snmpTrapDestination struct {
 	Address         string `json:"address,omitempty"`
 	Community       string `json:"community,omitempty"`
+	Version         string `json:"version,omitempty"`
 	NetworkInstance string `json:"network-instance,omitempty"`
 	AdminState      string `json:"admin-state,omitempty"`
+	// SNMPv3
+	SecurityLevel string `json:"security-level,omitempty"`
+	UserName      string `json:"user-name,omitempty"`
+	AuthProtocol  string `json:"authentication-protocol,omitempty"`
+	AuthKey       string `json:"authentication-key,omitempty"`
+	PrivProtocol  string `json:"privacy-protocol,omitempty"`
+	PrivKey       string `json:"privacy-key,omitempty"`
+	ContextName   string `json:"context-name,omitempty"`
+	// informs
+	Retries int    `json:"retries,omitempty"`
+	Timeout int    `json:"timeout,omitempty"`
+	Backoff string `json:"backoff,omitempty"`
+	// source address
+	SourceAddress   string `json:"source-address,omitempty"`
+	SourceInterface string `json:"source-interface,omitempty"`
+	TrapAddress     bool   `json:"trap-address,omitempty"`
+	// notification filters, trap definition names, tags or glob patterns
+	IncludeTrapDefinition []string `json:"include-trap-definition,omitempty"`
+	ExcludeTrapDefinition []string `json:"exclude-trap-definition,omitempty"`
+	IncludeTag            []string `json:"include-tag,omitempty"`
+	ExcludeTag            []string `json:"exclude-tag,omitempty"`
+	// notifications queued before being dropped
+	QueueSize int `json:"queue-size,omitempty"`
+	// state
+	Statistics *destinationStats `json:"statistics,omitempty"`
 	// OperState       string `json:"oper-state,omitempty"`
+	ResolvedAddress *resolvedAddress `json:"resolved-address,omitempty"`
 
-	ip   string
+	// IP address or host name
+	host string
 	port uint16
 }
 
@@ -127,12 +221,25 @@ START:
 		time.Sleep(retryInterval)
 		goto START
 	}
+	// failed subscriptions are retried after this interval
+	a.tg.Config.RetryTimer = subscriptionRetryInterval
+	a.getter = a.tg
 
 	//
 	nwInstStream := a.agent.StartNwInstNotificationStream(ctx)
 	cfgStream := a.agent.StartConfigNotificationStream(ctx)
 	go a.updateTelemetryCh(ctx)
+	go a.publishStatistics(ctx)
+	go a.runRenderWorker(ctx)
+	a.updateTrapFilesTelemetry(nil)
+	a.updateTrapDefinitionsTelemetry()
+	a.initEngine(ctx)
+	if a.uptimeSource != UptimeSourceApp {
+		go a.watchBootTime(ctx)
+	}
+	a.loadGlobals(ctx)
 	go a.StartSubscriptions(ctx)
+	go a.watchTrapDir(ctx)
 	for {
 		select {
 		case nwInstEvent := <-nwInstStream:
@@ -191,6 +298,12 @@ func (a *app) handleNwInstCfg(ctx context.Context, nwInst *ndk.NetworkInstanceNo
 	case ndk.SdkMgrOperation_Delete:
 		delete(a.config.nwInst, key.GetInstName())
 	}
+	// reopen the sockets of the senders using this network instance
+	for _, s := range a.config.senders {
+		if s.dest.NetworkInstance == key.GetInstName() {
+			s.reconnect.Store(true)
+		}
+	}
 }
 
 func (a *app) handleConfigEvent(ctx context.Context, cfg *ndk.ConfigNotification) {
@@ -214,6 +327,10 @@ func (a *app) handleConfigEvent(ctx context.Context, cfg *ndk.ConfigNotification
 	a.config.m.Lock()
 	defer a.config.m.Unlock()
 
+	// .system.snmp-traps
+	for _, txCfg := range a.config.trx[snmpTrapsPath] {
+		a.handleCfgSnmpTraps(ctx, txCfg)
+	}
 	// .system.snmp_traps.destination
 	for _, txCfg := range a.config.trx[snmpTrapsDestinationPath] {
 		switch txCfg.Op {
@@ -225,10 +342,55 @@ func (a *app) handleConfigEvent(ctx context.Context, cfg *ndk.ConfigNotification
 			a.handleCfgSnmpTrapDestinationDelete(ctx, txCfg)
 		}
 	}
+	// .system.snmp-traps.trap-definition and its nested lists
+	a.handleCfgTrapDefinitions(ctx)
 
 	a.config.trx = make(map[string][]*ndk.ConfigNotification)
 }
 
+type snmpTrapsConfig struct {
+	FanOut   int    `json:"fan-out,omitempty"`
+	EngineID string `json:"engine-id,omitempty"`
+}
+
+func (a *app) handleCfgSnmpTraps(ctx context.Context, cfg *ndk.ConfigNotification) {
+	trapsConfig := new(snmpTrapsConfig)
+	if cfg.Op != ndk.SdkMgrOperation_Delete {
+		err := json.Unmarshal([]byte(cfg.GetData().GetJson()), trapsConfig)
+		if err != nil {
+			log.Errorf("failed to unmarshal config data from path %s: %v", cfg.Key.JsPath, err)
+			return
+		}
+	}
+	if trapsConfig.FanOut <= 0 {
+		trapsConfig.FanOut = defaultFanOut
+	}
+	log.Infof("got SNMP traps config: %#v", trapsConfig)
+	a.config.fanOut.setLimit(trapsConfig.FanOut)
+
+	var engineID string
+	if trapsConfig.EngineID != "" {
+		var err error
+		engineID, err = parseEngineID(trapsConfig.EngineID)
+		if err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+	}
+	changed, err := a.engine.setConfiguredID(engineID)
+	if err != nil {
+		log.Errorf("failed to persist SNMP engine state: %v", err)
+	}
+	if !changed {
+		return
+	}
+	a.updateEngineTelemetry()
+	// reopen the senders sockets so that SNMPv3 clients use the new engine parameters
+	for _, s := range a.config.senders {
+		s.reconnect.Store(true)
+	}
+}
+
 func (a *app) handleCfgSnmpTrapDestinationCreate(ctx context.Context, cfg *ndk.ConfigNotification) {
 	key := cfg.GetKey().GetKeys()[0]
 	destinationConfig := new(snmpTrapDestination)
@@ -244,23 +406,21 @@ func (a *app) handleCfgSnmpTrapDestinationCreate(ctx context.Context, cfg *ndk.C
 	}
 	destinationConfig.Address = key
 	// parse address
-	host, port, err := net.SplitHostPort(key)
+	destinationConfig.host, destinationConfig.port, err = parseDestinationAddress(key)
 	if err != nil {
 		log.Errorf("failed to parse SNMP destination address %q: %v", key, err)
 		return
 	}
-	destinationConfig.ip = host
-	p, err := strconv.Atoi(port)
-	if err != nil {
-		log.Errorf("failed to parse SNMP destination address %q: %v", key, err)
+	if err = destinationConfig.validateUSM(); err != nil {
+		log.Errorf("invalid SNMP destination %q: %v", key, err)
 		return
 	}
-	destinationConfig.port = uint16(p)
 	//
+	destinationConfig.Statistics = newDestinationStats()
+	destinationConfig.ResolvedAddress = newResolvedAddress()
 	a.config.destinations[destinationConfig.Address] = destinationConfig
-	telemPath := fmt.Sprintf("%s{.address==\"%s\"}", snmpTrapsDestinationPath, key)
-	log.Infof("updating telemetry data with %q : %#v", telemPath, destinationConfig)
-	updateTelemetryCh(a.tuCh, telemPath, destinationConfig)
+	a.setSender(ctx, destinationConfig)
+	a.updateDestinationTelemetry(destinationConfig)
 }
 
 func (a *app) handleCfgSnmpTrapDestinationUpdate(ctx context.Context, cfg *ndk.ConfigNotification) {
@@ -278,28 +438,117 @@ func (a *app) handleCfgSnmpTrapDestinationUpdate(ctx context.Context, cfg *ndk.C
 	}
 	destinationConfig.Address = key
 	// parse address
-	host, port, err := net.SplitHostPort(key)
+	destinationConfig.host, destinationConfig.port, err = parseDestinationAddress(key)
 	if err != nil {
 		log.Errorf("failed to parse SNMP destination address %q: %v", key, err)
 		return
 	}
-	destinationConfig.ip = host
-	p, err := strconv.Atoi(port)
-	if err != nil {
-		log.Errorf("failed to parse SNMP destination address %q: %v", key, err)
+	if err = destinationConfig.validateUSM(); err != nil {
+		log.Errorf("invalid SNMP destination %q: %v", key, err)
 		return
 	}
-	destinationConfig.port = uint16(p)
 
+	if old, ok := a.config.destinations[destinationConfig.Address]; ok {
+		destinationConfig.Statistics = old.Statistics
+	} else {
+		destinationConfig.Statistics = newDestinationStats()
+	}
+	destinationConfig.ResolvedAddress = newResolvedAddress()
 	a.config.destinations[destinationConfig.Address] = destinationConfig
-	telemPath := fmt.Sprintf("%s{.address==\"%s\"}", snmpTrapsDestinationPath, key)
-	log.Infof("updating telemetry data with %q : %#v", telemPath, destinationConfig)
-	updateTelemetryCh(a.tuCh, telemPath, destinationConfig)
+	a.setSender(ctx, destinationConfig)
+	a.updateDestinationTelemetry(destinationConfig)
 }
 
 func (a *app) handleCfgSnmpTrapDestinationDelete(ctx context.Context, cfg *ndk.ConfigNotification) {
 	key := cfg.GetKey().GetKeys()[0]
 	delete(a.config.destinations, key)
-	telemPath := fmt.Sprintf("%s{.name==\"%s\"}", snmpTrapsDestinationPath, key)
-	a.deleteTelemetry(ctx, telemPath)
+	if s, ok := a.config.senders[key]; ok {
+		delete(a.config.senders, key)
+		s.stop(false)
+	}
+	a.deleteTelemetry(ctx, destinationTelemPath(key))
+}
+
+// setSender creates and starts the destination sender,
+// the previous one, if any, is stopped after sending its queued notifications.
+// must be called with the config lock held.
+func (a *app) setSender(ctx context.Context, dest *snmpTrapDestination) {
+	if s, ok := a.config.senders[dest.Address]; ok {
+		s.stop(true)
+	}
+	s := newSender(a, dest)
+	a.config.senders[dest.Address] = s
+	go s.run(ctx)
+}
+
+// destinationNetNS returns the namespace name of the destination network instance,
+// it returns false if the network instance is unknown or not oper UP.
+func (a *app) destinationNetNS(dest *snmpTrapDestination) (string, bool) {
+	a.config.m.RLock()
+	defer a.config.m.RUnlock()
+	netInst, ok := a.config.nwInst[dest.NetworkInstance]
+	if !ok {
+		log.Errorf("unknown network instance name: %s", dest.NetworkInstance)
+		return "", false
+	}
+	if !netInst.OperIsUp {
+		log.Debugf("destination %q: network instance %q is not oper UP", dest.Address, dest.NetworkInstance)
+		return "", false
+	}
+	return fmt.Sprintf("%s-%s", netInst.BaseName, dest.NetworkInstance), true
+}
+
+func destinationTelemPath(address string) string {
+	return fmt.Sprintf("%s{.address==\"%s\"}", snmpTrapsDestinationPath, address)
+}
+
+func (a *app) updateDestinationTelemetry(dest *snmpTrapDestination) {
+	telemPath := destinationTelemPath(dest.Address)
+	log.Debugf("updating telemetry data with %q : %#v", telemPath, dest)
+	updateTelemetryCh(a.tuCh, telemPath, dest)
+}
+
+// timeout returns the inform acknowledgement timeout of the destination.
+func (d *snmpTrapDestination) timeout() time.Duration {
+	if d.Timeout <= 0 {
+		return defaultInformTimeout
+	}
+	return time.Duration(d.Timeout) * time.Second
+}
+
+// queueSize returns the notification queue size of the destination.
+func (d *snmpTrapDestination) queueSize() int {
+	if d.QueueSize <= 0 {
+		return defaultQueueSize
+	}
+	return d.QueueSize
+}
+
+// parseDestinationAddress parses a destination address formatted as
+// host, host:port, IPv6, or [IPv6]:port.
+// host is either an IP address or a host name, the port defaults to 162.
+func parseDestinationAddress(addr string) (string, uint16, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// no port
+		host = addr
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		}
+		port = strconv.Itoa(defaultTrapPort)
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("missing host")
+	}
+	if strings.ContainsAny(host, "[]/ ") {
+		return "", 0, fmt.Errorf("invalid host %q", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q: %v", port, err)
+	}
+	if p == 0 {
+		return "", 0, fmt.Errorf("invalid port 0")
+	}
+	return host, uint16(p), nil
 }
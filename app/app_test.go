@@ -0,0 +1,44 @@
+package app
+
+import "testing"
+
+func TestParseDestinationAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		addr     string
+		wantHost string
+		wantPort uint16
+		wantErr  bool
+	}{
+		{name: "ipv4_port", addr: "10.0.0.1:1162", wantHost: "10.0.0.1", wantPort: 1162},
+		{name: "ipv4_default_port", addr: "10.0.0.1", wantHost: "10.0.0.1", wantPort: 162},
+		{name: "hostname_port", addr: "collector.example.com:1162", wantHost: "collector.example.com", wantPort: 1162},
+		{name: "hostname_default_port", addr: "collector", wantHost: "collector", wantPort: 162},
+		{name: "ipv6_default_port", addr: "2001:db8::1", wantHost: "2001:db8::1", wantPort: 162},
+		{name: "bracketed_ipv6_default_port", addr: "[2001:db8::1]", wantHost: "2001:db8::1", wantPort: 162},
+		{name: "bracketed_ipv6_port", addr: "[2001:db8::1]:1162", wantHost: "2001:db8::1", wantPort: 1162},
+		{name: "empty", addr: "", wantErr: true},
+		{name: "missing_host", addr: ":162", wantErr: true},
+		{name: "empty_port", addr: "10.0.0.1:", wantErr: true},
+		{name: "port_not_a_number", addr: "10.0.0.1:snmp", wantErr: true},
+		{name: "port_out_of_range", addr: "10.0.0.1:65536", wantErr: true},
+		{name: "port_zero", addr: "10.0.0.1:0", wantErr: true},
+		{name: "host_with_slash", addr: "10.0.0.0/24", wantErr: true},
+		{name: "host_with_space", addr: "collector 1:162", wantErr: true},
+		{name: "unbalanced_brackets", addr: "[2001:db8::1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, err := parseDestinationAddress(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDestinationAddress(%q) error = %v, wantErr %v", tt.addr, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if host != tt.wantHost || port != tt.wantPort {
+				t.Errorf("parseDestinationAddress(%q) = %q, %d, want %q, %d", tt.addr, host, port, tt.wantHost, tt.wantPort)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nokia/srlinux-ndk-go/ndk"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	snmpTrapsTrapDefinitionPath            = snmpTrapsPath + ".trap-definition"
+	snmpTrapsTrapDefinitionPublishPath     = snmpTrapsTrapDefinitionPath + ".trigger.publish"
+	snmpTrapsTrapDefinitionTaskPath        = snmpTrapsTrapDefinitionPath + ".task"
+	snmpTrapsTrapDefinitionTaskPublishPath = snmpTrapsTrapDefinitionTaskPath + ".publish"
+	snmpTrapsTrapDefinitionBindingPath     = snmpTrapsTrapDefinitionPath + ".trap.binding"
+)
+
+// trapDefinitionConfig is a trap definition configured under
+// .system.snmp-traps.trap-definition.
+// Its nested lists are delivered in their own config notifications.
+type trapDefinitionConfig struct {
+	AdminState string   `json:"admin-state,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Trigger    *struct {
+		Path      string `json:"path,omitempty"`
+		Condition string `json:"condition,omitempty"`
+		Mode      string `json:"mode,omitempty"`
+		// seconds
+		SampleInterval    int    `json:"sample-interval,omitempty"`
+		HeartbeatInterval int    `json:"heartbeat-interval,omitempty"`
+		SuppressRedundant bool   `json:"suppress-redundant,omitempty"`
+		On                string `json:"on,omitempty"`
+	} `json:"trigger,omitempty"`
+	Trap *struct {
+		Inform    bool   `json:"inform,omitempty"`
+		OID       string `json:"oid,omitempty"`
+		Community string `json:"community,omitempty"`
+	} `json:"trap,omitempty"`
+
+	// trigger published variables expressions by name
+	publish map[string]string
+	// tasks by id
+	tasks map[string]*taskConfig
+	// bindings by id
+	bindings map[string]*bindingConfig
+}
+
+type taskConfig struct {
+	Name string `json:"name,omitempty"`
+	GNMI *struct {
+		RPC      string `json:"rpc,omitempty"`
+		Path     string `json:"path,omitempty"`
+		Encoding string `json:"encoding,omitempty"`
+	} `json:"gnmi,omitempty"`
+
+	// published variables expressions by name
+	publish map[string]string
+}
+
+type bindingConfig struct {
+	OID   string `json:"oid,omitempty"`
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+type publishConfig struct {
+	Expression string `json:"expression,omitempty"`
+}
+
+func newTrapDefinitionConfig() *trapDefinitionConfig {
+	return &trapDefinitionConfig{
+		publish:  make(map[string]string),
+		tasks:    make(map[string]*taskConfig),
+		bindings: make(map[string]*bindingConfig),
+	}
+}
+
+// handleCfgTrapDefinitions handles the trap definitions config notifications
+// of a commit, compiles the changed definitions and updates the subscriptions.
+// must be called with the config lock held.
+func (a *app) handleCfgTrapDefinitions(ctx context.Context) {
+	changed := a.applyCfgTrapDefinitions()
+	if len(changed) == 0 {
+		return
+	}
+	a.trapsM.Lock()
+	a.compileCfgTrapDefinitions(changed)
+	a.trapsM.Unlock()
+
+	a.updateTrapDefinitionsTelemetry()
+	a.updateSubscriptions(ctx)
+}
+
+// applyCfgTrapDefinitions applies the trap definitions config notifications
+// of a commit to the configured trap definitions, and returns the names
+// of the changed definitions.
+// The nested lists notifications may be received before the trap definition one.
+// must be called with the config lock held.
+func (a *app) applyCfgTrapDefinitions() map[string]struct{} {
+	changed := make(map[string]struct{})
+	// .system.snmp-traps.trap-definition
+	for _, txCfg := range a.config.trx[snmpTrapsTrapDefinitionPath] {
+		name := txCfg.GetKey().GetKeys()[0]
+		changed[name] = struct{}{}
+		if txCfg.Op == ndk.SdkMgrOperation_Delete {
+			delete(a.config.trapDefinitions, name)
+			continue
+		}
+		d := newTrapDefinitionConfig()
+		err := json.Unmarshal([]byte(txCfg.GetData().GetJson()), d)
+		if err != nil {
+			log.Errorf("failed to unmarshal config data from path %s: %v", txCfg.Key.JsPath, err)
+			continue
+		}
+		if old, ok := a.config.trapDefinitions[name]; ok {
+			d.publish, d.tasks, d.bindings = old.publish, old.tasks, old.bindings
+		}
+		a.config.trapDefinitions[name] = d
+	}
+	// .system.snmp-traps.trap-definition.trigger.publish
+	for _, txCfg := range a.config.trx[snmpTrapsTrapDefinitionPublishPath] {
+		keys := txCfg.GetKey().GetKeys()
+		d, ok := a.trapDefinitionConfig(keys[0], txCfg.Op)
+		if !ok {
+			continue
+		}
+		changed[keys[0]] = struct{}{}
+		setPublishConfig(d.publish, keys[1], txCfg)
+	}
+	// .system.snmp-traps.trap-definition.task
+	for _, txCfg := range a.config.trx[snmpTrapsTrapDefinitionTaskPath] {
+		keys := txCfg.GetKey().GetKeys()
+		d, ok := a.trapDefinitionConfig(keys[0], txCfg.Op)
+		if !ok {
+			continue
+		}
+		changed[keys[0]] = struct{}{}
+		if txCfg.Op == ndk.SdkMgrOperation_Delete {
+			delete(d.tasks, keys[1])
+			continue
+		}
+		tc := &taskConfig{publish: make(map[string]string)}
+		err := json.Unmarshal([]byte(txCfg.GetData().GetJson()), tc)
+		if err != nil {
+			log.Errorf("failed to unmarshal config data from path %s: %v", txCfg.Key.JsPath, err)
+			continue
+		}
+		if old, ok := d.tasks[keys[1]]; ok {
+			tc.publish = old.publish
+		}
+		d.tasks[keys[1]] = tc
+	}
+	// .system.snmp-traps.trap-definition.task.publish
+	for _, txCfg := range a.config.trx[snmpTrapsTrapDefinitionTaskPublishPath] {
+		keys := txCfg.GetKey().GetKeys()
+		d, ok := a.trapDefinitionConfig(keys[0], txCfg.Op)
+		if !ok {
+			continue
+		}
+		changed[keys[0]] = struct{}{}
+		tc, ok := d.tasks[keys[1]]
+		if !ok {
+			if txCfg.Op == ndk.SdkMgrOperation_Delete {
+				continue
+			}
+			tc = &taskConfig{publish: make(map[string]string)}
+			d.tasks[keys[1]] = tc
+		}
+		setPublishConfig(tc.publish, keys[2], txCfg)
+	}
+	// .system.snmp-traps.trap-definition.trap.binding
+	for _, txCfg := range a.config.trx[snmpTrapsTrapDefinitionBindingPath] {
+		keys := txCfg.GetKey().GetKeys()
+		d, ok := a.trapDefinitionConfig(keys[0], txCfg.Op)
+		if !ok {
+			continue
+		}
+		changed[keys[0]] = struct{}{}
+		if txCfg.Op == ndk.SdkMgrOperation_Delete {
+			delete(d.bindings, keys[1])
+			continue
+		}
+		bc := new(bindingConfig)
+		err := json.Unmarshal([]byte(txCfg.GetData().GetJson()), bc)
+		if err != nil {
+			log.Errorf("failed to unmarshal config data from path %s: %v", txCfg.Key.JsPath, err)
+			continue
+		}
+		d.bindings[keys[1]] = bc
+	}
+	return changed
+}
+
+// compileCfgTrapDefinitions compiles the changed configured trap definitions,
+// a definition that fails to compile keeps its running version.
+// must be called with the config and traps locks held.
+func (a *app) compileCfgTrapDefinitions(changed map[string]struct{}) {
+	for name := range changed {
+		d, ok := a.config.trapDefinitions[name]
+		if !ok || !d.defines() {
+			// deleted, or only setting the admin-state of a file trap definition
+			delete(a.cfgTraps, name)
+			delete(a.cfgTrapErrs, name)
+			continue
+		}
+		t, err := d.trapDefinition(name)
+		if err != nil {
+			log.Errorf("failed to compile configured trap definition %q: %v", name, err)
+			if _, ok := a.cfgTraps[name]; ok {
+				log.Warnf("keeping the running version of configured trap definition %q", name)
+			}
+			a.cfgTrapErrs[name] = err
+			continue
+		}
+		a.cfgTraps[name] = t
+		delete(a.cfgTrapErrs, name)
+	}
+	a.disabledTraps = make(map[string]struct{})
+	for name, d := range a.config.trapDefinitions {
+		if d.AdminState == "disable" {
+			a.disabledTraps[name] = struct{}{}
+		}
+	}
+	a.mergeTraps()
+}
+
+// trapDefinitionConfig returns the configured trap definition name,
+// it is created for a child list create or update notification received
+// before the trap definition one.
+func (a *app) trapDefinitionConfig(name string, op ndk.SdkMgrOperation) (*trapDefinitionConfig, bool) {
+	d, ok := a.config.trapDefinitions[name]
+	if ok {
+		return d, true
+	}
+	if op == ndk.SdkMgrOperation_Delete {
+		return nil, false
+	}
+	d = newTrapDefinitionConfig()
+	a.config.trapDefinitions[name] = d
+	return d, true
+}
+
+func setPublishConfig(publish map[string]string, name string, cfg *ndk.ConfigNotification) {
+	if cfg.Op == ndk.SdkMgrOperation_Delete {
+		delete(publish, name)
+		return
+	}
+	pc := new(publishConfig)
+	err := json.Unmarshal([]byte(cfg.GetData().GetJson()), pc)
+	if err != nil {
+		log.Errorf("failed to unmarshal config data from path %s: %v", cfg.Key.JsPath, err)
+		return
+	}
+	publish[name] = pc.Expression
+}
+
+// defines returns true if the configured trap definition has content,
+// as opposed to only setting the admin-state of a trap definition file.
+func (d *trapDefinitionConfig) defines() bool {
+	return d.Trigger != nil && d.Trigger.Path != "" ||
+		d.Trap != nil && d.Trap.OID != "" ||
+		len(d.publish) > 0 || len(d.tasks) > 0 || len(d.bindings) > 0
+}
+
+// trapDefinition builds and compiles the trap definition from its config.
+func (d *trapDefinitionConfig) trapDefinition(name string) (*trapDefinition, error) {
+	tr := &trigger{Publish: publishList(d.publish)}
+	if d.Trigger != nil {
+		tr.Path = d.Trigger.Path
+		tr.Condition = d.Trigger.Condition
+		tr.On = d.Trigger.On
+		tr.subscriptionMode = subscriptionMode{
+			Mode:              d.Trigger.Mode,
+			SampleInterval:    interval(time.Duration(d.Trigger.SampleInterval) * time.Second),
+			HeartbeatInterval: interval(time.Duration(d.Trigger.HeartbeatInterval) * time.Second),
+			SuppressRedundant: d.Trigger.SuppressRedundant,
+		}
+	}
+	t := &trapDefinition{
+		Name:    name,
+		Tags:    d.Tags,
+		Trigger: triggers{tr},
+	}
+	for _, id := range sortedIDs(d.tasks) {
+		tc := d.tasks[id]
+		tsk := &task{
+			Name:    tc.Name,
+			Publish: publishList(tc.publish),
+		}
+		if tsk.Name == "" {
+			tsk.Name = id
+		}
+		if tc.GNMI != nil && tc.GNMI.Path != "" {
+			tsk.GNMI = &gNMITask{
+				RPC:      tc.GNMI.RPC,
+				Path:     tc.GNMI.Path,
+				Encoding: tc.GNMI.Encoding,
+			}
+		}
+		t.Tasks = append(t.Tasks, tsk)
+	}
+	if d.Trap != nil {
+		t.TrapPDU = &trapPDU{
+			InformPDU: d.Trap.Inform,
+			OID:       d.Trap.OID,
+			Community: d.Trap.Community,
+		}
+		for _, id := range sortedIDs(d.bindings) {
+			bc := d.bindings[id]
+			t.TrapPDU.Bindings = append(t.TrapPDU.Bindings, &binding{
+				OID:   bc.OID,
+				Type:  bc.Type,
+				Value: bc.Value,
+			})
+		}
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	t.checksum = sha256.Sum256(b)
+	err = t.parseCode()
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// publishList returns the published variables in the trap definition
+// file format, sorted by name.
+func publishList(publish map[string]string) []map[string]string {
+	names := make([]string, 0, len(publish))
+	for name := range publish {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rs := make([]map[string]string, 0, len(names))
+	for _, name := range names {
+		rs = append(rs, map[string]string{name: publish[name]})
+	}
+	return rs
+}
+
+// sortedIDs returns the numeric list keys of m in ascending order.
+func sortedIDs[T any](m map[string]T) []string {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		ii, _ := strconv.Atoi(ids[i])
+		ij, _ := strconv.Atoi(ids[j])
+		return ii < ij
+	})
+	return ids
+}
@@ -0,0 +1,246 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nokia/srlinux-ndk-go/ndk"
+)
+
+func cfgNotification(path string, op ndk.SdkMgrOperation, json string, keys ...string) *ndk.ConfigNotification {
+	return &ndk.ConfigNotification{
+		Op:   op,
+		Key:  &ndk.ConfigKey{JsPath: path, Keys: keys},
+		Data: &ndk.ConfigData{DataType: &ndk.ConfigData_Json{Json: json}},
+	}
+}
+
+func TestApplyCfgTrapDefinitions(t *testing.T) {
+	const (
+		create = ndk.SdkMgrOperation_Create
+		del    = ndk.SdkMgrOperation_Delete
+	)
+	definition := cfgNotification(snmpTrapsTrapDefinitionPath, create,
+		`{"trigger": {"path": "/interface[name=*]/oper-state"}, "trap": {"oid": "1.3.6.1.6.3.1.1.5.3"}}`, "t1")
+	publish := cfgNotification(snmpTrapsTrapDefinitionPublishPath, create, `{"expression": "$keys.interface_name"}`, "t1", "if_name")
+	task := cfgNotification(snmpTrapsTrapDefinitionTaskPath, create,
+		`{"gnmi": {"path": "\"/interface[name=\" + $if_name + \"]/ifindex\""}}`, "t1", "1")
+	taskPublish := cfgNotification(snmpTrapsTrapDefinitionTaskPublishPath, create, `{"expression": ".values.\"/interface/ifindex\""}`, "t1", "1", "ifindex")
+	binding := cfgNotification(snmpTrapsTrapDefinitionBindingPath, create,
+		`{"oid": "\".1.3.6.1.2.1.2.2.1.1.\" + $ifindex", "type": "int", "value": "$ifindex | tonumber"}`, "t1", "1")
+
+	type want struct {
+		configured bool
+		compiled   bool
+		// compile error of the last commit
+		failed  bool
+		publish []string
+		tasks   []string
+		// task 1 published variables
+		taskPublish []string
+		bindings    []string
+	}
+	tests := []struct {
+		name    string
+		commits [][]*ndk.ConfigNotification
+		want    want
+	}{
+		{
+			name:    "single_commit",
+			commits: [][]*ndk.ConfigNotification{{definition, publish, task, taskPublish, binding}},
+			want: want{
+				configured: true, compiled: true,
+				publish: []string{"if_name"}, tasks: []string{"1"}, taskPublish: []string{"ifindex"}, bindings: []string{"1"},
+			},
+		},
+		{
+			name:    "children_before_parent",
+			commits: [][]*ndk.ConfigNotification{{binding, taskPublish, publish}, {task}, {definition}},
+			want: want{
+				configured: true, compiled: true,
+				publish: []string{"if_name"}, tasks: []string{"1"}, taskPublish: []string{"ifindex"}, bindings: []string{"1"},
+			},
+		},
+		{
+			name: "child_deleted",
+			commits: [][]*ndk.ConfigNotification{
+				{definition, publish, task, taskPublish, binding},
+				{cfgNotification(snmpTrapsTrapDefinitionTaskPublishPath, del, "", "t1", "1", "ifindex")},
+			},
+			// $ifindex is no longer defined, the running version is kept
+			want: want{
+				configured: true, compiled: true, failed: true,
+				publish: []string{"if_name"}, tasks: []string{"1"}, taskPublish: []string{}, bindings: []string{"1"},
+			},
+		},
+		{
+			name: "task_deleted",
+			commits: [][]*ndk.ConfigNotification{
+				{definition, publish, task, taskPublish, binding},
+				{
+					cfgNotification(snmpTrapsTrapDefinitionTaskPath, del, "", "t1", "1"),
+					cfgNotification(snmpTrapsTrapDefinitionBindingPath, del, "", "t1", "1"),
+					cfgNotification(snmpTrapsTrapDefinitionBindingPath, create,
+						`{"oid": "\".1.3.6.1.2.1.2.2.1.2.0\"", "type": "octetString", "value": "$if_name"}`, "t1", "2"),
+				},
+			},
+			want: want{
+				configured: true, compiled: true,
+				publish: []string{"if_name"}, tasks: []string{}, bindings: []string{"2"},
+			},
+		},
+		{
+			name: "deleted",
+			commits: [][]*ndk.ConfigNotification{
+				{definition, publish, task, taskPublish, binding},
+				{cfgNotification(snmpTrapsTrapDefinitionPath, del, "", "t1")},
+			},
+		},
+		{
+			name: "child_delete_without_parent",
+			commits: [][]*ndk.ConfigNotification{
+				{cfgNotification(snmpTrapsTrapDefinitionBindingPath, del, "", "t1", "1")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := New(WithTrapDir(t.TempDir()))
+			for _, commit := range tt.commits {
+				for _, cfg := range commit {
+					a.handleConfigEvent(context.Background(), cfg)
+				}
+				a.compileCfgTrapDefinitions(a.applyCfgTrapDefinitions())
+				a.config.trx = make(map[string][]*ndk.ConfigNotification)
+			}
+			d, configured := a.config.trapDefinitions["t1"]
+			_, compiled := a.cfgTraps["t1"]
+			if configured != tt.want.configured || compiled != tt.want.compiled {
+				t.Fatalf("got configured %v compiled %v (error %v), want %v and %v",
+					configured, compiled, a.cfgTrapErrs["t1"], tt.want.configured, tt.want.compiled)
+			}
+			if !configured {
+				return
+			}
+			_, failed := a.cfgTrapErrs["t1"]
+			got := want{
+				configured: true,
+				compiled:   compiled,
+				failed:     failed,
+				publish:    sortedIDs(d.publish),
+				tasks:      sortedIDs(d.tasks),
+				bindings:   sortedIDs(d.bindings),
+			}
+			if tc, ok := d.tasks["1"]; ok {
+				got.taskPublish = sortedIDs(tc.publish)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapDefinitionConfig(t *testing.T) {
+	newConfig := func(js string) *trapDefinitionConfig {
+		d := newTrapDefinitionConfig()
+		if err := json.Unmarshal([]byte(js), d); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	t.Run("defines", func(t *testing.T) {
+		tests := []struct {
+			name string
+			d    *trapDefinitionConfig
+			want bool
+		}{
+			{name: "admin_state_only", d: &trapDefinitionConfig{AdminState: "disable"}, want: false},
+			{name: "empty_trigger", d: newConfig(`{"trigger": {}}`), want: false},
+			{name: "trigger_path", d: newConfig(`{"trigger": {"path": "/interface/oper-state"}}`), want: true},
+			{name: "trap_oid", d: newConfig(`{"trap": {"oid": "1.3.6.1.6.3.1.1.5.3"}}`), want: true},
+			{name: "publish", d: &trapDefinitionConfig{publish: map[string]string{"a": "1"}}, want: true},
+			{name: "task", d: &trapDefinitionConfig{tasks: map[string]*taskConfig{"1": {}}}, want: true},
+			{name: "binding", d: &trapDefinitionConfig{bindings: map[string]*bindingConfig{"1": {}}}, want: true},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if got := tt.d.defines(); got != tt.want {
+					t.Errorf("defines() = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("ordered_ids", func(t *testing.T) {
+		d := newConfig(`{
+			"trigger": {"path": "/interface[name=*]/oper-state", "mode": "sample", "sample-interval": 30},
+			"trap": {"oid": "1.3.6.1.6.3.1.1.5.3"}
+		}`)
+		d.publish = map[string]string{"b": "2", "a": "1", "c": "3"}
+		d.tasks = map[string]*taskConfig{
+			"10": {Name: "third", publish: map[string]string{"z": "$a"}},
+			"2":  {publish: map[string]string{"y": "$a"}},
+			"1":  {Name: "first", publish: map[string]string{"x": "$a"}},
+		}
+		d.bindings = map[string]*bindingConfig{
+			"20": {OID: `".1.3.6.1.4.1.3"`, Type: "int", Value: "$z"},
+			"3":  {OID: `".1.3.6.1.4.1.2"`, Type: "int", Value: "$y"},
+			"1":  {OID: `".1.3.6.1.4.1.1"`, Type: "int", Value: "$x"},
+		}
+		td, err := d.trapDefinition("t1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := td.Trigger[0]
+		if tr.Mode != "sample" || time.Duration(tr.SampleInterval) != 30*time.Second {
+			t.Errorf("got trigger mode %q sample-interval %s, want sample and 30s", tr.Mode, time.Duration(tr.SampleInterval))
+		}
+		publish := make([]string, 0)
+		for _, mkv := range tr.Publish {
+			for k := range mkv {
+				publish = append(publish, k)
+			}
+		}
+		tasks := make([]string, 0)
+		for _, tsk := range td.Tasks {
+			tasks = append(tasks, tsk.Name)
+		}
+		bindings := make([]string, 0)
+		for _, b := range td.TrapPDU.Bindings {
+			bindings = append(bindings, b.OID)
+		}
+		if want := []string{"a", "b", "c"}; !reflect.DeepEqual(publish, want) {
+			t.Errorf("got publish %v, want %v", publish, want)
+		}
+		// a task without name is named after its id
+		if want := []string{"first", "2", "third"}; !reflect.DeepEqual(tasks, want) {
+			t.Errorf("got tasks %v, want %v", tasks, want)
+		}
+		if want := []string{`".1.3.6.1.4.1.1"`, `".1.3.6.1.4.1.2"`, `".1.3.6.1.4.1.3"`}; !reflect.DeepEqual(bindings, want) {
+			t.Errorf("got bindings %v, want %v", bindings, want)
+		}
+	})
+
+	t.Run("compile_errors", func(t *testing.T) {
+		tests := []struct {
+			name string
+			d    *trapDefinitionConfig
+		}{
+			{name: "missing_trap", d: newConfig(`{"trigger": {"path": "/interface/oper-state"}}`)},
+			{name: "missing_trigger", d: newConfig(`{"trap": {"oid": "1.3.6.1.6.3.1.1.5.3"}}`)},
+			{name: "missing_bindings", d: newConfig(`{"trigger": {"path": "/interface/oper-state"}, "trap": {"oid": "1.3.6.1.6.3.1.1.5.3"}}`)},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := tt.d.trapDefinition("t1"); err == nil {
+					t.Error("expected an error")
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,273 @@
+package app
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmic/api"
+	"github.com/openconfig/gnmic/formatters"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Nokia private enterprise number
+	enterpriseNumber = 6527
+	// snmpEngineID length bounds (RFC 3411)
+	minEngineIDLen = 5
+	maxEngineIDLen = 32
+
+	chassisMACPath           = "/platform/chassis/hw-mac-address"
+	engineStateFile          = "/etc/opt/snmp-traps/engine.json"
+	snmpTrapsLocalEnginePath = snmpTrapsPath + ".local-engine"
+)
+
+// snmpEngine is the local SNMPv3 engine,
+// authoritative for the notifications sent as traps.
+// the snmpEngineBoots of each engine ID is persisted in a state file and
+// incremented each time the app starts or the engine ID changes.
+type snmpEngine struct {
+	m         *sync.RWMutex
+	id        string
+	boots     uint32
+	startTime time.Time
+	// engine boots state file path
+	stateFile string
+	// engine ID derived from the chassis MAC or the host name
+	defaultID string
+	// configured engine ID
+	configuredID string
+}
+
+type engineState struct {
+	EngineID    string `json:"engine-id,omitempty"`
+	EngineBoots uint32 `json:"engine-boots,omitempty"`
+}
+
+// engineBoots is the content of the engine state file,
+// the last snmpEngineBoots of each engine ID used.
+// The boots of the default and the configured engine IDs are kept
+// so that applying the configured ID after a restart does not reset them.
+type engineBoots struct {
+	Boots map[string]uint32 `json:"engine-boots,omitempty"`
+}
+
+func newSNMPEngine() *snmpEngine {
+	return &snmpEngine{
+		m:         new(sync.RWMutex),
+		defaultID: hostnameEngineID(),
+		startTime: time.Now(),
+		stateFile: engineStateFile,
+	}
+}
+
+// params returns the engine ID, boots and time.
+func (e *snmpEngine) params() (string, uint32, uint32) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.id, e.boots, uint32(time.Since(e.startTime).Seconds())
+}
+
+func (e *snmpEngine) state() *engineState {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return &engineState{
+		EngineID:    formatEngineID(e.id),
+		EngineBoots: e.boots,
+	}
+}
+
+// setDefaultID sets the engine ID used when none is configured.
+func (e *snmpEngine) setDefaultID(id string) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.defaultID = id
+	return e.reboot()
+}
+
+// setConfiguredID sets the configured engine ID, an empty id
+// reverts to the default one.
+// it returns true if the engine ID changed.
+func (e *snmpEngine) setConfiguredID(id string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if id == e.configuredID && e.id != "" {
+		return false, nil
+	}
+	e.configuredID = id
+	oldID := e.id
+	err := e.reboot()
+	return e.id != oldID, err
+}
+
+// reboot sets the engine ID and increments its boots counter,
+// the counter starts from 1 for an engine ID never used before.
+// must be called with the engine lock held.
+func (e *snmpEngine) reboot() error {
+	id := e.configuredID
+	if id == "" {
+		id = e.defaultID
+	}
+	if id == e.id {
+		return nil
+	}
+	st, err := readEngineBoots(e.stateFile)
+	if err != nil {
+		log.Warnf("failed to read SNMP engine state file: %v", err)
+		st = &engineBoots{Boots: make(map[string]uint32)}
+	}
+	key := formatEngineID(id)
+	switch boots := st.Boots[key]; {
+	case boots >= math.MaxInt32:
+		// latched, RFC 3414 section 2.2.2
+		e.boots = math.MaxInt32
+	default:
+		e.boots = boots + 1
+	}
+	e.id = id
+	e.startTime = time.Now()
+	log.Infof("SNMP engine ID %s, boots %d", key, e.boots)
+	st.Boots[key] = e.boots
+	return writeEngineBoots(e.stateFile, st)
+}
+
+func readEngineBoots(name string) (*engineBoots, error) {
+	st := new(engineBoots)
+	b, err := os.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(b, st)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if st.Boots == nil {
+		st.Boots = make(map[string]uint32)
+	}
+	return st, nil
+}
+
+func writeEngineBoots(name string, st *engineBoots) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+	// write then rename so that a crash does not leave a truncated file
+	tmp := name + ".tmp"
+	err = os.WriteFile(tmp, b, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// initEngine derives the default engine ID from the chassis MAC address
+// and starts the local engine.
+func (a *app) initEngine(ctx context.Context) {
+	mac, err := a.getChassisMAC(ctx)
+	if err != nil {
+		log.Errorf("failed to get chassis MAC address, using host name based engine ID: %v", err)
+		err = a.engine.setDefaultID(a.engine.defaultID)
+	} else {
+		err = a.engine.setDefaultID(macEngineID(mac))
+	}
+	if err != nil {
+		log.Errorf("failed to persist SNMP engine state: %v", err)
+	}
+	a.updateEngineTelemetry()
+}
+
+func (a *app) getChassisMAC(ctx context.Context) (net.HardwareAddr, error) {
+	req, err := api.NewGetRequest(
+		api.Path(chassisMACPath),
+		api.EncodingASCII(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := a.tg.Get(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	evs, err := formatters.GetResponseToEventMsgs(rsp, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, ev := range evs {
+		for _, v := range ev.Values {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected %s value type %T", chassisMACPath, v)
+			}
+			return net.ParseMAC(s)
+		}
+	}
+	return nil, fmt.Errorf("no value returned for %s", chassisMACPath)
+}
+
+func (a *app) updateEngineTelemetry() {
+	updateTelemetryCh(a.tuCh, snmpTrapsLocalEnginePath, a.engine.state())
+}
+
+func engineIDPrefix() []byte {
+	return binary.BigEndian.AppendUint32(nil, 0x80000000|enterpriseNumber)
+}
+
+// macEngineID builds an RFC 3411 MAC address format snmpEngineID.
+func macEngineID(mac net.HardwareAddr) string {
+	b := append(engineIDPrefix(), 0x03)
+	b = append(b, mac...)
+	return string(b)
+}
+
+// hostnameEngineID builds an RFC 3411 text format snmpEngineID
+// from the host name.
+func hostnameEngineID() string {
+	b := append(engineIDPrefix(), 0x04)
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		h = "srl-snmp-traps"
+	}
+	b = append(b, h...)
+	if len(b) > maxEngineIDLen {
+		b = b[:maxEngineIDLen]
+	}
+	return string(b)
+}
+
+// parseEngineID parses a hex encoded engine ID,
+// octets are optionally separated by colons.
+func parseEngineID(s string) (string, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil {
+		return "", fmt.Errorf("invalid engine ID %q: %v", s, err)
+	}
+	if len(b) < minEngineIDLen || len(b) > maxEngineIDLen {
+		return "", fmt.Errorf("invalid engine ID %q: length must be between %d and %d octets", s, minEngineIDLen, maxEngineIDLen)
+	}
+	return string(b), nil
+}
+
+func formatEngineID(id string) string {
+	b := []byte(id)
+	octets := make([]string, 0, len(b))
+	for _, o := range b {
+		octets = append(octets, hex.EncodeToString([]byte{o}))
+	}
+	return strings.Join(octets, ":")
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestSNMPEngineRestart(t *testing.T) {
+	const (
+		defaultID    = "\x80\x00\x19\x7f\x03\x1a\x2b\x3c\x4d\x5e\x6f"
+		configuredID = "\x80\x00\x19\x7f\x04test"
+		otherID      = "\x80\x00\x19\x7f\x04other"
+	)
+	// each step is an app start: the default engine ID is set
+	// before the configured one, if any, is received.
+	type start struct {
+		configuredID string
+		wantID       string
+		wantBoots    uint32
+	}
+	tests := []struct {
+		name   string
+		starts []start
+	}{
+		{
+			name: "default_id",
+			starts: []start{
+				{wantID: defaultID, wantBoots: 1},
+				{wantID: defaultID, wantBoots: 2},
+				{wantID: defaultID, wantBoots: 3},
+			},
+		},
+		{
+			name: "configured_id",
+			starts: []start{
+				{configuredID: configuredID, wantID: configuredID, wantBoots: 1},
+				{configuredID: configuredID, wantID: configuredID, wantBoots: 2},
+				{configuredID: configuredID, wantID: configuredID, wantBoots: 3},
+			},
+		},
+		{
+			name: "configured_then_removed",
+			starts: []start{
+				{configuredID: configuredID, wantID: configuredID, wantBoots: 1},
+				{wantID: defaultID, wantBoots: 2},
+				{configuredID: configuredID, wantID: configuredID, wantBoots: 2},
+			},
+		},
+		{
+			name: "configured_id_changed",
+			starts: []start{
+				{configuredID: configuredID, wantID: configuredID, wantBoots: 1},
+				{configuredID: otherID, wantID: otherID, wantBoots: 1},
+				{configuredID: configuredID, wantID: configuredID, wantBoots: 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateFile := filepath.Join(t.TempDir(), "engine.json")
+			for i, st := range tt.starts {
+				e := newSNMPEngine()
+				e.stateFile = stateFile
+				if err := e.setDefaultID(defaultID); err != nil {
+					t.Fatalf("start %d: setDefaultID: %v", i, err)
+				}
+				if _, err := e.setConfiguredID(st.configuredID); err != nil {
+					t.Fatalf("start %d: setConfiguredID: %v", i, err)
+				}
+				id, boots, _ := e.params()
+				if id != st.wantID || boots != st.wantBoots {
+					t.Errorf("start %d: got engine ID %s boots %d, want %s boots %d",
+						i, formatEngineID(id), boots, formatEngineID(st.wantID), st.wantBoots)
+				}
+			}
+		})
+	}
+}
+
+func TestSNMPEngineBootsLatched(t *testing.T) {
+	const id = "\x80\x00\x19\x7f\x04test"
+	stateFile := filepath.Join(t.TempDir(), "engine.json")
+	err := writeEngineBoots(stateFile, &engineBoots{
+		Boots: map[string]uint32{formatEngineID(id): math.MaxInt32},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newSNMPEngine()
+	e.stateFile = stateFile
+	if _, err := e.setConfiguredID(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, boots, _ := e.params(); boots != math.MaxInt32 {
+		t.Errorf("got boots %d, want %d", boots, math.MaxInt32)
+	}
+}
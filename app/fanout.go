@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"sync"
+)
+
+// fanOut limits the number of destinations sending concurrently.
+// Its limit can be changed while notifications are being sent,
+// when it is lowered, the in-flight sends above the new limit complete
+// before another send can start.
+type fanOut struct {
+	m     *sync.Mutex
+	limit int
+	inUse int
+	// closed when a send completes or the limit changes
+	wake chan struct{}
+}
+
+func newFanOut(limit int) *fanOut {
+	return &fanOut{
+		m:     new(sync.Mutex),
+		limit: limit,
+		wake:  make(chan struct{}),
+	}
+}
+
+// acquire blocks until a send can start or ctx is done.
+func (f *fanOut) acquire(ctx context.Context) error {
+	for {
+		f.m.Lock()
+		if f.inUse < f.limit {
+			f.inUse++
+			f.m.Unlock()
+			return nil
+		}
+		wake := f.wake
+		f.m.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// release marks a send started with acquire as completed.
+func (f *fanOut) release() {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.inUse--
+	f.broadcast()
+}
+
+func (f *fanOut) setLimit(limit int) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if limit == f.limit {
+		return
+	}
+	f.limit = limit
+	f.broadcast()
+}
+
+// broadcast wakes up the sends waiting in acquire.
+// must be called with the lock held.
+func (f *fanOut) broadcast() {
+	close(f.wake)
+	f.wake = make(chan struct{})
+}
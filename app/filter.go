@@ -0,0 +1,70 @@
+package app
+
+import (
+	"path"
+)
+
+// filterMatch describes the destination filter
+// that accepted or dropped a notification.
+type filterMatch struct {
+	TrapDefinition string `json:"trap-definition,omitempty"`
+	Filter         string `json:"filter,omitempty"`
+	Value          string `json:"value,omitempty"`
+	Action         string `json:"action,omitempty"`
+}
+
+// filter applies the destination include/exclude filters to the notification.
+// exclude filters are evaluated first, if include filters are configured,
+// at least one of them must match for the notification to be sent.
+// it returns true if the notification is to be sent to the destination,
+// and the filter that matched, nil if the destination has no filters.
+func (d *snmpTrapDestination) filter(n *notification) (bool, *filterMatch) {
+	if m := matchTrapDefinition("exclude-trap-definition", d.ExcludeTrapDefinition, n); m != nil {
+		m.Action = "drop"
+		return false, m
+	}
+	if m := matchTag("exclude-tag", d.ExcludeTag, n); m != nil {
+		m.Action = "drop"
+		return false, m
+	}
+	if len(d.IncludeTrapDefinition) == 0 && len(d.IncludeTag) == 0 {
+		return true, nil
+	}
+	if m := matchTrapDefinition("include-trap-definition", d.IncludeTrapDefinition, n); m != nil {
+		m.Action = "send"
+		return true, m
+	}
+	if m := matchTag("include-tag", d.IncludeTag, n); m != nil {
+		m.Action = "send"
+		return true, m
+	}
+	return false, &filterMatch{
+		TrapDefinition: n.name,
+		Filter:         "no-include-match",
+		Action:         "drop",
+	}
+}
+
+// matchTrapDefinition matches the notification trap definition name
+// against a list of names or glob patterns.
+func matchTrapDefinition(filter string, patterns []string, n *notification) *filterMatch {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, n.name); ok {
+			return &filterMatch{TrapDefinition: n.name, Filter: filter, Value: p}
+		}
+	}
+	return nil
+}
+
+// matchTag matches the notification trap definition tags
+// against a list of tags or glob patterns.
+func matchTag(filter string, patterns []string, n *notification) *filterMatch {
+	for _, p := range patterns {
+		for _, tag := range n.tags {
+			if ok, _ := path.Match(p, tag); ok {
+				return &filterMatch{TrapDefinition: n.name, Filter: filter, Value: p}
+			}
+		}
+	}
+	return nil
+}
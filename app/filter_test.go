@@ -0,0 +1,112 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDestinationFilter(t *testing.T) {
+	n := &notification{name: "bgp_neighbor_state", tags: []string{"bgp", "routing"}}
+	tests := []struct {
+		name      string
+		dest      *snmpTrapDestination
+		wantSend  bool
+		wantMatch *filterMatch
+	}{
+		{
+			name:     "no_filters",
+			dest:     &snmpTrapDestination{},
+			wantSend: true,
+		},
+		{
+			name:     "include_trap_definition",
+			dest:     &snmpTrapDestination{IncludeTrapDefinition: []string{"interface_oper_state", "bgp_neighbor_state"}},
+			wantSend: true,
+			wantMatch: &filterMatch{
+				TrapDefinition: "bgp_neighbor_state", Filter: "include-trap-definition",
+				Value: "bgp_neighbor_state", Action: "send",
+			},
+		},
+		{
+			name:     "include_trap_definition_glob",
+			dest:     &snmpTrapDestination{IncludeTrapDefinition: []string{"bgp_*"}},
+			wantSend: true,
+			wantMatch: &filterMatch{
+				TrapDefinition: "bgp_neighbor_state", Filter: "include-trap-definition",
+				Value: "bgp_*", Action: "send",
+			},
+		},
+		{
+			name:     "include_tag_glob",
+			dest:     &snmpTrapDestination{IncludeTag: []string{"rout*"}},
+			wantSend: true,
+			wantMatch: &filterMatch{
+				TrapDefinition: "bgp_neighbor_state", Filter: "include-tag",
+				Value: "rout*", Action: "send",
+			},
+		},
+		{
+			name: "no_include_match",
+			dest: &snmpTrapDestination{IncludeTrapDefinition: []string{"interface_*"}, IncludeTag: []string{"interface"}},
+			wantMatch: &filterMatch{
+				TrapDefinition: "bgp_neighbor_state", Filter: "no-include-match", Action: "drop",
+			},
+		},
+		{
+			name: "exclude_trap_definition",
+			dest: &snmpTrapDestination{ExcludeTrapDefinition: []string{"bgp_neighbor_state"}},
+			wantMatch: &filterMatch{
+				TrapDefinition: "bgp_neighbor_state", Filter: "exclude-trap-definition",
+				Value: "bgp_neighbor_state", Action: "drop",
+			},
+		},
+		{
+			name: "exclude_tag",
+			dest: &snmpTrapDestination{ExcludeTag: []string{"routing"}},
+			wantMatch: &filterMatch{
+				TrapDefinition: "bgp_neighbor_state", Filter: "exclude-tag",
+				Value: "routing", Action: "drop",
+			},
+		},
+		{
+			name:     "exclude_not_matched",
+			dest:     &snmpTrapDestination{ExcludeTag: []string{"interface"}},
+			wantSend: true,
+		},
+		{
+			name: "exclude_before_include",
+			dest: &snmpTrapDestination{
+				IncludeTrapDefinition: []string{"bgp_neighbor_state"},
+				ExcludeTag:            []string{"bgp"},
+			},
+			wantMatch: &filterMatch{
+				TrapDefinition: "bgp_neighbor_state", Filter: "exclude-tag",
+				Value: "bgp", Action: "drop",
+			},
+		},
+		{
+			name: "exclude_trap_definition_before_exclude_tag",
+			dest: &snmpTrapDestination{
+				ExcludeTrapDefinition: []string{"bgp_*"},
+				ExcludeTag:            []string{"bgp"},
+			},
+			wantMatch: &filterMatch{
+				TrapDefinition: "bgp_neighbor_state", Filter: "exclude-trap-definition",
+				Value: "bgp_*", Action: "drop",
+			},
+		},
+		{
+			name:     "glob_matches_whole_name",
+			dest:     &snmpTrapDestination{ExcludeTrapDefinition: []string{"bgp"}},
+			wantSend: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			send, m := tt.dest.filter(n)
+			if send != tt.wantSend || !reflect.DeepEqual(m, tt.wantMatch) {
+				t.Errorf("filter() = %v, %+v, want %v, %+v", send, m, tt.wantSend, tt.wantMatch)
+			}
+		})
+	}
+}
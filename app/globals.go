@@ -0,0 +1,258 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/itchyny/gojq"
+	"github.com/openconfig/gnmic/api"
+	"github.com/openconfig/gnmic/formatters"
+	"github.com/openconfig/gnmic/utils"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// globalsVar is the jq variable holding the global variables,
+// available to every trap definition.
+const globalsVar = "$globals"
+
+// globalDefinition is a global variable, fetched at startup and
+// refreshed through an ON_CHANGE subscription to its path.
+type globalDefinition struct {
+	// gNMI path
+	Path string `yaml:"path,omitempty"`
+	// optional jq expression run on the path event,
+	// defaults to the path value
+	Value string `yaml:"value,omitempty"`
+
+	// path without keys, as found in the events values
+	valuePath string
+	valueCode *gojq.Code
+}
+
+// globals holds the global variables definitions and values.
+// They are defined in a YAML file in the trap directory
+// with a top level "globals" map.
+type globals struct {
+	m        *sync.RWMutex
+	defs     map[string]*globalDefinition
+	values   map[string]any
+	file     string
+	checksum [sha256.Size]byte
+}
+
+type globalsFile struct {
+	Globals map[string]*globalDefinition `yaml:"globals,omitempty"`
+}
+
+func newGlobals() *globals {
+	return &globals{
+		m:      new(sync.RWMutex),
+		defs:   make(map[string]*globalDefinition),
+		values: make(map[string]any),
+	}
+}
+
+// isGlobals returns true if the YAML document b defines global variables.
+func isGlobals(b []byte) bool {
+	gf := new(globalsFile)
+	return yaml.Unmarshal(b, gf) == nil && len(gf.Globals) > 0
+}
+
+// get returns a copy of the global variables values,
+// passed to the jq expressions as $globals.
+func (gl *globals) get() map[string]any {
+	gl.m.RLock()
+	defer gl.m.RUnlock()
+	rs := make(map[string]any, len(gl.values))
+	for k, v := range gl.values {
+		rs[k] = v
+	}
+	return rs
+}
+
+// set sets the global variables values, the values of the variables
+// not in values are kept.
+func (gl *globals) set(values map[string]any) {
+	gl.m.Lock()
+	defer gl.m.Unlock()
+	for k, v := range values {
+		if _, ok := gl.defs[k]; !ok {
+			continue
+		}
+		gl.values[k] = v
+	}
+}
+
+// paths returns the gNMI paths of the global variables.
+func (gl *globals) paths() []string {
+	gl.m.RLock()
+	defer gl.m.RUnlock()
+	ps := make([]string, 0, len(gl.defs))
+	for _, d := range gl.defs {
+		ps = append(ps, d.Path)
+	}
+	sort.Strings(ps)
+	return ps
+}
+
+// load reads the global variables definitions from the trap directory.
+// it returns true if they changed.
+func (gl *globals) load(dir string) (bool, error) {
+	defs, file, checksum, err := readGlobals(dir)
+	if err != nil {
+		return false, err
+	}
+	gl.m.Lock()
+	defer gl.m.Unlock()
+	if file == gl.file && checksum == gl.checksum {
+		return false, nil
+	}
+	gl.defs = defs
+	gl.file = file
+	gl.checksum = checksum
+	values := make(map[string]any, len(defs))
+	for name := range defs {
+		if v, ok := gl.values[name]; ok {
+			values[name] = v
+		}
+	}
+	gl.values = values
+	log.Infof("loaded %d global variable(s) from %q", len(defs), file)
+	return true, nil
+}
+
+// readGlobals reads and compiles the global variables definitions
+// from the globals file under dir, if any.
+func readGlobals(dir string) (map[string]*globalDefinition, string, [sha256.Size]byte, error) {
+	var file string
+	var checksum [sha256.Size]byte
+	defs := make(map[string]*globalDefinition)
+	err := filepath.WalkDir(dir,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if path == dir {
+					return err
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			ext := filepath.Ext(path)
+			if ext != ".yaml" && ext != ".yml" {
+				return nil
+			}
+			b, err := os.ReadFile(path)
+			if err != nil || !isGlobals(b) {
+				return nil
+			}
+			if file != "" {
+				return fmt.Errorf("globals defined in both %q and %q", file, path)
+			}
+			gf := new(globalsFile)
+			err = yaml.Unmarshal(b, gf)
+			if err != nil {
+				return fmt.Errorf("%q: %v", path, err)
+			}
+			for name, gd := range gf.Globals {
+				if gd == nil || gd.Path == "" {
+					return fmt.Errorf("%q: global %q missing \"path\"", path, name)
+				}
+				gp, err := utils.ParsePath(gd.Path)
+				if err != nil {
+					return fmt.Errorf("%q: global %q: %v", path, name, err)
+				}
+				gd.valuePath = "/" + utils.GnmiPathToXPath(gp, true)
+				if gd.Value != "" {
+					gd.valueCode, err = parseJQ(gd.Value)
+					if err != nil {
+						return fmt.Errorf("%q: global %q value parse failed: %v", path, name, err)
+					}
+				}
+			}
+			file = path
+			checksum = sha256.Sum256(b)
+			defs = gf.Globals
+			return nil
+		})
+	if err != nil {
+		return nil, "", checksum, err
+	}
+	return defs, file, checksum, nil
+}
+
+// valuesFromEvent returns the global variables values found in the event.
+func (gl *globals) valuesFromEvent(ev *formatters.EventMsg) map[string]any {
+	gl.m.RLock()
+	defer gl.m.RUnlock()
+	var input map[string]any
+	rs := make(map[string]any)
+	for name, gd := range gl.defs {
+		v, ok := ev.Values[gd.valuePath]
+		if !ok {
+			continue
+		}
+		if gd.valueCode == nil {
+			rs[name] = v
+			continue
+		}
+		if input == nil {
+			input = ev.ToMap()
+		}
+		r, err := runJQ(gd.valueCode, input)
+		if err != nil {
+			log.Errorf("global %q: failed to evaluate value: %v", name, err)
+			continue
+		}
+		rs[name] = r
+	}
+	return rs
+}
+
+// fetchGlobals reads the global variables values with a gNMI Get request per path.
+func (a *app) fetchGlobals(ctx context.Context) {
+	for _, p := range a.globals.paths() {
+		req, err := api.NewGetRequest(
+			api.Path(p),
+			api.EncodingASCII(),
+		)
+		if err != nil {
+			log.Errorf("failed to create a get request for global path %q: %v", p, err)
+			continue
+		}
+		rsp, err := a.getter.Get(ctx, req)
+		if err != nil {
+			log.Errorf("failed to get global path %q: %v", p, err)
+			continue
+		}
+		evs, err := formatters.GetResponseToEventMsgs(rsp, nil)
+		if err != nil {
+			log.Errorf("failed to convert global path %q get response: %v", p, err)
+			continue
+		}
+		for _, ev := range evs {
+			a.globals.set(a.globals.valuesFromEvent(ev))
+		}
+	}
+	log.Debugf("globals: %v", a.globals.get())
+}
+
+// loadGlobals reads the global variables definitions from the trap directory,
+// and fetches their values if they changed.
+func (a *app) loadGlobals(ctx context.Context) {
+	changed, err := a.globals.load(a.trapDir)
+	if err != nil {
+		log.Errorf("failed to load global variables: %v", err)
+		return
+	}
+	if changed {
+		a.fetchGlobals(ctx)
+	}
+}
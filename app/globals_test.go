@@ -0,0 +1,205 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmic/formatters"
+	"github.com/openconfig/gnmic/utils"
+)
+
+func TestGlobalsLoad(t *testing.T) {
+	const globalsFile = `globals:
+  hostname:
+    path: /system/name/host-name
+  version:
+    path: /system/information/version
+    value: '.values."/system/information/version" | split("-")[0]'
+`
+	tests := []struct {
+		name string
+		// files written to the trap directory
+		files     map[string]string
+		wantNames []string
+		wantPaths []string
+		wantErr   string
+	}{
+		{
+			name:      "globals_file",
+			files:     map[string]string{"globals.yaml": globalsFile, "trap.yaml": "name: trap\n"},
+			wantNames: []string{"hostname", "version"},
+			wantPaths: []string{"/system/information/version", "/system/name/host-name"},
+		},
+		{
+			name:      "no_globals_file",
+			files:     map[string]string{"trap.yaml": "name: trap\n"},
+			wantNames: []string{},
+			wantPaths: []string{},
+		},
+		{
+			name:      "nested_directory",
+			files:     map[string]string{"lib/globals.yml": globalsFile},
+			wantNames: []string{"hostname", "version"},
+			wantPaths: []string{"/system/information/version", "/system/name/host-name"},
+		},
+		{
+			name:    "two_globals_files",
+			files:   map[string]string{"a.yaml": globalsFile, "b.yaml": globalsFile},
+			wantErr: "globals defined in both",
+		},
+		{
+			name:    "missing_path",
+			files:   map[string]string{"globals.yaml": "globals:\n  hostname:\n    value: '1'\n"},
+			wantErr: `global "hostname" missing "path"`,
+		},
+		{
+			name:    "invalid_value",
+			files:   map[string]string{"globals.yaml": "globals:\n  hostname:\n    path: /system/name/host-name\n    value: '.values |'\n"},
+			wantErr: `global "hostname" value parse failed`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			gl := newGlobals()
+			_, err := gl.load(dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := make([]string, 0, len(gl.defs))
+			for name := range gl.defs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.wantNames) || !reflect.DeepEqual(gl.paths(), tt.wantPaths) {
+				t.Errorf("got globals %v with paths %v, want %v with %v", names, gl.paths(), tt.wantNames, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestGlobalsReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "globals.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gl := newGlobals()
+	write("globals:\n  hostname:\n    path: /system/name/host-name\n  version:\n    path: /system/information/version\n")
+	if changed, err := gl.load(dir); err != nil || !changed {
+		t.Fatalf("first load: got changed %v, error %v, want changed", changed, err)
+	}
+	gl.set(map[string]any{"hostname": "leaf1", "version": "v23.10.1", "unknown": 1})
+	if changed, err := gl.load(dir); err != nil || changed {
+		t.Fatalf("unchanged file: got changed %v, error %v, want unchanged", changed, err)
+	}
+	// the values of the remaining variables are kept
+	write("globals:\n  hostname:\n    path: /system/name/host-name\n")
+	if changed, err := gl.load(dir); err != nil || !changed {
+		t.Fatalf("changed file: got changed %v, error %v, want changed", changed, err)
+	}
+	if got, want := gl.get(), map[string]any{"hostname": "leaf1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got values %v, want %v", got, want)
+	}
+}
+
+func TestGlobalsValuesFromEvent(t *testing.T) {
+	gl := newGlobals()
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "globals.yaml"), []byte(`globals:
+  hostname:
+    path: /system/name/host-name
+  version:
+    path: /system/information/version
+    value: '.values."/system/information/version" | split("-")[0]'
+  chassis:
+    path: /platform/chassis/type
+    value: '.values.missing.type'
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gl.load(dir); err != nil {
+		t.Fatal(err)
+	}
+	// ON_CHANGE subscription response
+	response := func(updates map[string]string) *gnmi.SubscribeResponse {
+		n := &gnmi.Notification{Timestamp: 1}
+		for p, v := range updates {
+			gp, err := utils.ParsePath(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n.Update = append(n.Update, &gnmi.Update{
+				Path: gp,
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}},
+			})
+		}
+		return &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: n}}
+	}
+	tests := []struct {
+		name    string
+		updates map[string]string
+		want    map[string]any
+	}{
+		{
+			name:    "path_value",
+			updates: map[string]string{"/system/name/host-name": "leaf1"},
+			want:    map[string]any{"hostname": "leaf1"},
+		},
+		{
+			name:    "value_expression",
+			updates: map[string]string{"/system/information/version": "v23.10.1-163-gf1ec0ee4f3"},
+			want:    map[string]any{"version": "v23.10.1"},
+		},
+		{
+			name:    "null_expression_result",
+			updates: map[string]string{"/platform/chassis/type": "7220 IXR-D2L"},
+			want:    map[string]any{"chassis": nil},
+		},
+		{
+			name:    "other_path",
+			updates: map[string]string{"/system/name/domain-name": "example.com"},
+			want:    map[string]any{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evs, err := formatters.ResponseToEventMsgs("", response(tt.updates), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]any)
+			for _, ev := range evs {
+				for k, v := range gl.valuesFromEvent(ev) {
+					got[k] = v
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
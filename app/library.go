@@ -0,0 +1,216 @@
+package app
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// library holds named tasks, variable sets and binding sets
+// shared by the trap definitions including it.
+// It is a YAML file in the trap directory with a top level "library" name.
+type library struct {
+	Name  string           `yaml:"library,omitempty"`
+	Tasks map[string]*task `yaml:"tasks,omitempty"`
+	// variable sets, run as a task without gNMI request
+	Variables map[string][]map[string]string `yaml:"variables,omitempty"`
+	Bindings  map[string][]*binding          `yaml:"bindings,omitempty"`
+
+	file     string
+	checksum [sha256.Size]byte
+}
+
+// isLibrary returns true if the YAML document b is a library.
+func isLibrary(b []byte) bool {
+	l := new(struct {
+		Library string `yaml:"library,omitempty"`
+	})
+	return yaml.Unmarshal(b, l) == nil && l.Library != ""
+}
+
+// readLibraries reads the library files under dir.
+// The load error of each failed library file is returned in a map keyed by file path.
+// The returned error is only set if dir can't be walked.
+func readLibraries(dir string) (map[string]*library, map[string]error, error) {
+	libs := make(map[string]*library)
+	fileErrs := make(map[string]error)
+	err := filepath.WalkDir(dir,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// reported when reading the trap definitions
+				if path == dir {
+					return err
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			ext := filepath.Ext(path)
+			if ext != ".yaml" && ext != ".yml" {
+				return nil
+			}
+			b, err := os.ReadFile(path)
+			if err != nil || !isLibrary(b) {
+				return nil
+			}
+			l := new(library)
+			err = yaml.Unmarshal(b, l)
+			if err == nil {
+				err = l.validate()
+			}
+			if err != nil {
+				log.Errorf("failed to load library file %q: %v", path, err)
+				fileErrs[path] = err
+				return nil
+			}
+			if ol, ok := libs[l.Name]; ok {
+				fileErrs[path] = fmt.Errorf("library %q already defined in %q", l.Name, ol.file)
+				log.Errorf("failed to load library file %q: %v", path, fileErrs[path])
+				return nil
+			}
+			l.file = path
+			l.checksum = sha256.Sum256(b)
+			libs[l.Name] = l
+			return nil
+		})
+	if err != nil {
+		return nil, nil, err
+	}
+	return libs, fileErrs, nil
+}
+
+// validate checks that the library tasks and bindings do not
+// reference other library entries, "use" is only resolved in trap definitions.
+func (l *library) validate() error {
+	for name, tsk := range l.Tasks {
+		if tsk.Use != "" {
+			return fmt.Errorf("library %q task %q: nested \"use\" %q is not supported", l.Name, name, tsk.Use)
+		}
+	}
+	for name, bs := range l.Bindings {
+		for idx, b := range bs {
+			if b.Use != "" {
+				return fmt.Errorf("library %q binding set %q index %d: nested \"use\" %q is not supported", l.Name, name, idx, b.Use)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveLibraries replaces the tasks and bindings entries with a "use" reference
+// by copies of the referenced library tasks, variable sets and binding sets.
+// Libraries are searched in include order.
+// The copies are compiled with the trap definition, so that their variables
+// are checked against the definition scope.
+func (t *trapDefinition) resolveLibraries() error {
+	libs := make([]*library, 0, len(t.Include))
+	for _, name := range t.Include {
+		l, ok := t.libraries[name]
+		if !ok {
+			return fmt.Errorf("trap definition %q includes unknown library %q", t.Name, name)
+		}
+		libs = append(libs, l)
+	}
+
+	tasks := make([]*task, 0, len(t.Tasks))
+	for idx, tsk := range t.Tasks {
+		if tsk.Use == "" {
+			tasks = append(tasks, tsk)
+			continue
+		}
+		lt := findLibraryTask(libs, tsk.Use)
+		if lt == nil {
+			return fmt.Errorf("trap definition %q task index %d: %q not found in included libraries %v", t.Name, idx, tsk.Use, t.Include)
+		}
+		tasks = append(tasks, lt)
+	}
+	t.Tasks = tasks
+
+	if t.TrapPDU == nil {
+		return nil
+	}
+	bindings := make([]*binding, 0, len(t.TrapPDU.Bindings))
+	for idx, b := range t.TrapPDU.Bindings {
+		if b.Use == "" {
+			bindings = append(bindings, b)
+			continue
+		}
+		lbs := findLibraryBindings(libs, b.Use)
+		if lbs == nil {
+			return fmt.Errorf("trap definition %q binding index %d: %q not found in included libraries %v", t.Name, idx, b.Use, t.Include)
+		}
+		bindings = append(bindings, lbs...)
+	}
+	t.TrapPDU.Bindings = bindings
+	return nil
+}
+
+// findLibraryTask returns a copy of the task or variable set name
+// from the first library defining it.
+func findLibraryTask(libs []*library, name string) *task {
+	for _, l := range libs {
+		if lt, ok := l.Tasks[name]; ok {
+			tsk := &task{
+				Name:    lt.Name,
+				Publish: lt.Publish,
+				library: l.Name,
+			}
+			if tsk.Name == "" {
+				tsk.Name = name
+			}
+			if lt.GNMI != nil {
+				gt := *lt.GNMI
+				tsk.GNMI = &gt
+			}
+			return tsk
+		}
+		if vs, ok := l.Variables[name]; ok {
+			return &task{
+				Name:    name,
+				Publish: vs,
+				library: l.Name,
+			}
+		}
+	}
+	return nil
+}
+
+// findLibraryBindings returns a copy of the binding set name
+// from the first library defining it.
+func findLibraryBindings(libs []*library, name string) []*binding {
+	for _, l := range libs {
+		lbs, ok := l.Bindings[name]
+		if !ok {
+			continue
+		}
+		rs := make([]*binding, 0, len(lbs))
+		for _, lb := range lbs {
+			rs = append(rs, &binding{
+				OID:     lb.OID,
+				Type:    lb.Type,
+				Value:   lb.Value,
+				library: l.Name,
+			})
+		}
+		return rs
+	}
+	return nil
+}
+
+// librariesChecksum returns the checksum of the libraries included
+// by the trap definition, they are part of the definition checksum.
+func (t *trapDefinition) librariesChecksum() []byte {
+	h := sha256.New()
+	for _, name := range t.Include {
+		if l, ok := t.libraries[name]; ok {
+			h.Write(l.checksum[:])
+		}
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestResolveLibraries(t *testing.T) {
+	const common = `library: common
+tasks:
+  get_admin_state:
+    gnmi:
+      rpc: get
+      path: '$if_path + "/admin-state"'
+    publish:
+      - admin_state: '[.values[]][0]'
+  unnamed:
+    name: renamed
+    publish:
+      - x: 1
+variables:
+  admin_up:
+    - admin_up: 1
+bindings:
+  sys_name:
+    - oid: '".1.3.6.1.2.1.1.5"'
+      type: octetString
+      value: '"common"'
+`
+	const override = `library: override
+tasks:
+  get_admin_state:
+    publish:
+      - admin_state: 2
+bindings:
+  sys_name:
+    - oid: '".1.3.6.1.2.1.1.5"'
+      type: octetString
+      value: '"override"'
+    - oid: '".1.3.6.1.2.1.1.6"'
+      type: octetString
+      value: '"location"'
+`
+	tests := []struct {
+		name         string
+		include      []string
+		tasks        []string
+		bindings     []string
+		wantTasks    []string
+		wantBindings []string
+		// library each task was copied from, empty for the definition ones
+		wantTaskLibs []string
+		wantErr      string
+	}{
+		{
+			name:         "task_and_binding",
+			include:      []string{"common"},
+			tasks:        []string{"get_admin_state"},
+			bindings:     []string{"sys_name"},
+			wantTasks:    []string{"get_admin_state"},
+			wantTaskLibs: []string{"common"},
+			wantBindings: []string{`"common"`},
+		},
+		{
+			name:         "variable_set_and_named_task",
+			include:      []string{"common"},
+			tasks:        []string{"admin_up", "unnamed"},
+			wantTasks:    []string{"admin_up", "renamed"},
+			wantTaskLibs: []string{"common", "common"},
+		},
+		{
+			name:         "include_order",
+			include:      []string{"override", "common"},
+			tasks:        []string{"get_admin_state", "admin_up"},
+			bindings:     []string{"sys_name"},
+			wantTasks:    []string{"get_admin_state", "admin_up"},
+			wantTaskLibs: []string{"override", "common"},
+			wantBindings: []string{`"override"`, `"location"`},
+		},
+		{
+			name:         "definition_entries_kept",
+			include:      []string{"common"},
+			tasks:        []string{"", "get_admin_state"},
+			bindings:     []string{"", "sys_name"},
+			wantTasks:    []string{"local", "get_admin_state"},
+			wantTaskLibs: []string{"", "common"},
+			wantBindings: []string{"1", `"common"`},
+		},
+		{
+			name:    "unknown_library",
+			include: []string{"common", "missing"},
+			wantErr: `includes unknown library "missing"`,
+		},
+		{
+			name:    "unknown_task",
+			include: []string{"common"},
+			tasks:   []string{"get_oper_state"},
+			wantErr: `task index 0: "get_oper_state" not found in included libraries [common]`,
+		},
+		{
+			name:     "unknown_binding_set",
+			include:  []string{"common"},
+			bindings: []string{"sys_location"},
+			wantErr:  `binding index 0: "sys_location" not found in included libraries [common]`,
+		},
+		{
+			name:    "not_included",
+			tasks:   []string{"get_admin_state"},
+			wantErr: `"get_admin_state" not found in included libraries []`,
+		},
+	}
+	libs := make(map[string]*library)
+	for _, y := range []string{common, override} {
+		l := new(library)
+		if err := yaml.Unmarshal([]byte(y), l); err != nil {
+			t.Fatal(err)
+		}
+		libs[l.Name] = l
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := &trapDefinition{Name: "test", Include: tt.include, libraries: libs, TrapPDU: &trapPDU{}}
+			for _, use := range tt.tasks {
+				if use == "" {
+					td.Tasks = append(td.Tasks, &task{Name: "local"})
+					continue
+				}
+				td.Tasks = append(td.Tasks, &task{Use: use})
+			}
+			for _, use := range tt.bindings {
+				if use == "" {
+					td.TrapPDU.Bindings = append(td.TrapPDU.Bindings, &binding{Value: "1"})
+					continue
+				}
+				td.TrapPDU.Bindings = append(td.TrapPDU.Bindings, &binding{Use: use})
+			}
+			err := td.resolveLibraries()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var tasks, taskLibs, bindings []string
+			for _, tsk := range td.Tasks {
+				tasks = append(tasks, tsk.Name)
+				taskLibs = append(taskLibs, tsk.library)
+			}
+			for _, b := range td.TrapPDU.Bindings {
+				bindings = append(bindings, b.Value)
+			}
+			if !reflect.DeepEqual(tasks, tt.wantTasks) || !reflect.DeepEqual(taskLibs, tt.wantTaskLibs) {
+				t.Errorf("got tasks %q from %q, want %q from %q", tasks, taskLibs, tt.wantTasks, tt.wantTaskLibs)
+			}
+			if !reflect.DeepEqual(bindings, tt.wantBindings) {
+				t.Errorf("got bindings %q, want %q", bindings, tt.wantBindings)
+			}
+		})
+	}
+}
+
+func TestReadLibraries(t *testing.T) {
+	tests := []struct {
+		name    string
+		library string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			library: `library: common
+tasks:
+  admin:
+    publish:
+      - a: 1
+`,
+		},
+		{
+			name: "nested_task_use",
+			library: `library: common
+tasks:
+  admin:
+    use: get_admin_state
+`,
+			wantErr: `library "common" task "admin": nested "use" "get_admin_state" is not supported`,
+		},
+		{
+			name: "nested_binding_use",
+			library: `library: common
+bindings:
+  system:
+    - use: sys_name
+`,
+			wantErr: `library "common" binding set "system" index 0: nested "use" "sys_name" is not supported`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "lib.yaml")
+			if err := os.WriteFile(path, []byte(tt.library), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			libs, fileErrs, err := readLibraries(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr == "" {
+				if len(fileErrs) != 0 || libs["common"] == nil {
+					t.Errorf("got libraries %v, errors %v, want library common", libs, fileErrs)
+				}
+				return
+			}
+			if err := fileErrs[path]; err == nil || err.Error() != tt.wantErr {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+			if _, ok := libs["common"]; ok {
+				t.Error("got library common loaded, want it rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"context"
+	"net"
+	"runtime"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netns"
+)
+
+// execInNetNS runs f in a dedicated OS thread switched to the named network namespace.
+// Sockets created by f stay bound to that namespace.
+// The thread namespace is restored before it is returned to the runtime,
+// if that fails the thread is left locked and is terminated when the goroutine exits.
+func execInNetNS(name string, f func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		orig, err := netns.Get()
+		if err != nil {
+			runtime.UnlockOSThread()
+			errCh <- err
+			return
+		}
+		defer orig.Close()
+		ns, err := netns.GetFromName(name)
+		if err != nil {
+			runtime.UnlockOSThread()
+			errCh <- err
+			return
+		}
+		defer ns.Close()
+		err = netns.Set(ns)
+		if err != nil {
+			runtime.UnlockOSThread()
+			errCh <- err
+			return
+		}
+		err = f()
+		if rerr := netns.Set(orig); rerr != nil {
+			log.Errorf("failed to restore original NS from %q: %v", name, rerr)
+			errCh <- err
+			return
+		}
+		runtime.UnlockOSThread()
+		errCh <- err
+	}()
+	return <-errCh
+}
+
+// nsResolver returns a DNS resolver sending its queries
+// from the named network namespace.
+func nsResolver(name string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var conn net.Conn
+			err := execInNetNS(name, func() error {
+				var err error
+				conn, err = new(net.Dialer).DialContext(ctx, network, address)
+				return err
+			})
+			return conn, err
+		},
+	}
+}
@@ -0,0 +1,274 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	trapDirPollInterval   = 10 * time.Second
+	snmpTrapsTrapFilePath = snmpTrapsPath + ".trap-file"
+)
+
+type subscriptions struct {
+	m *sync.Mutex
+	// subscription cancel functions by subscription name
+	cancelFns map[string]context.CancelFunc
+}
+
+// trapFileStatus is the load status of a trap definition file,
+// published as state.
+type trapFileStatus struct {
+	Path           string `json:"path,omitempty"`
+	Status         string `json:"status,omitempty"`
+	Error          string `json:"error,omitempty"`
+	TrapDefinition string `json:"trap-definition,omitempty"`
+	// the file failed to load and its previously loaded version is running
+	RunningPreviousVersion bool   `json:"running-previous-version,omitempty"`
+	LoadTime               string `json:"load-time,omitempty"`
+}
+
+type fileInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (a *app) getTraps() []*trapDefinition {
+	a.trapsM.RLock()
+	defer a.trapsM.RUnlock()
+	return a.traps
+}
+
+// watchTrapDir polls the trap definitions directory and
+// reloads the definitions when a file is added, modified or removed.
+func (a *app) watchTrapDir(ctx context.Context) {
+	ticker := time.NewTicker(trapDirPollInterval)
+	defer ticker.Stop()
+	last, err := scanTrapDir(a.trapDir)
+	if err != nil {
+		log.Errorf("failed to scan trap definitions directory %q: %v", a.trapDir, err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			files, err := scanTrapDir(a.trapDir)
+			if err != nil {
+				log.Errorf("failed to scan trap definitions directory %q: %v", a.trapDir, err)
+				continue
+			}
+			if sameFiles(last, files) {
+				continue
+			}
+			log.Infof("trap definitions directory %q changed, reloading", a.trapDir)
+			if a.reloadTraps(ctx) {
+				last = files
+			}
+		}
+	}
+}
+
+// reloadTraps reads and compiles the trap definitions, swaps them
+// with the running ones and updates the subscriptions of the changed trigger paths.
+// Notifications being handled keep using the definitions they started with.
+// it returns false if the definitions directory could not be read.
+func (a *app) reloadTraps(ctx context.Context) bool {
+	oldFiles, err := a.loadTraps()
+	if err != nil {
+		log.Errorf("failed to reload trap definitions, keeping the running ones: %v", err)
+		return false
+	}
+	log.Infof("reloaded %d trap definition(s)", len(a.getTraps()))
+	a.updateTrapFilesTelemetry(oldFiles)
+	a.updateTrapDefinitionsTelemetry()
+	a.loadGlobals(ctx)
+	a.updateSubscriptions(ctx)
+	return true
+}
+
+// loadTraps loads the trap definition files and swaps them with the running ones.
+// Files failing to load are quarantined: they are skipped, or, if a previous version
+// of the file was loaded, that version keeps running.
+// it returns the previous files load status.
+func (a *app) loadTraps() (map[string]*trapFileStatus, error) {
+	traps, fileErrs, err := readTrapsDefinition(a.trapDir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	files := make(map[string]*trapFileStatus, len(traps)+len(fileErrs))
+	names := make(map[string]struct{}, len(traps))
+	for _, t := range traps {
+		names[t.Name] = struct{}{}
+		files[t.file] = &trapFileStatus{
+			Path:           t.file,
+			Status:         "loaded",
+			TrapDefinition: t.Name,
+			LoadTime:       now,
+		}
+	}
+
+	a.trapsM.Lock()
+	defer a.trapsM.Unlock()
+	running := make(map[string]*trapDefinition, len(a.fileTraps))
+	for _, t := range a.fileTraps {
+		running[t.file] = t
+	}
+	for path, ferr := range fileErrs {
+		st := &trapFileStatus{
+			Path:     path,
+			Status:   "failed",
+			Error:    ferr.Error(),
+			LoadTime: now,
+		}
+		if t, ok := running[path]; ok {
+			if _, ok := names[t.Name]; !ok {
+				log.Warnf("trap definition file %q failed to load, keeping its running version", path)
+				names[t.Name] = struct{}{}
+				traps = append(traps, t)
+				st.TrapDefinition = t.Name
+				st.RunningPreviousVersion = true
+			}
+		}
+		files[path] = st
+	}
+	oldFiles := a.trapFiles
+	a.fileTraps = traps
+	a.trapFiles = files
+	a.mergeTraps()
+	return oldFiles, nil
+}
+
+// mergeTraps sets the running trap definitions from the trap directory
+// and the configured ones, the configured ones take precedence.
+// Definitions with admin-state disable are not running.
+// must be called with the traps lock held.
+func (a *app) mergeTraps() {
+	traps := make([]*trapDefinition, 0, len(a.fileTraps)+len(a.cfgTraps))
+	for _, t := range a.loadedTraps() {
+		if _, ok := a.disabledTraps[t.Name]; ok {
+			log.Debugf("trap definition %q is disabled", t.Name)
+			continue
+		}
+		traps = append(traps, t)
+	}
+	logTrapsDiff(a.traps, traps)
+	a.traps = traps
+	a.triggerStates.prune(traps)
+}
+
+// loadedTraps returns the trap definitions loaded from the trap directory
+// and the configured ones, whatever their admin-state.
+// must be called with the traps lock held.
+func (a *app) loadedTraps() []*trapDefinition {
+	traps := make([]*trapDefinition, 0, len(a.fileTraps)+len(a.cfgTraps))
+	for _, t := range a.fileTraps {
+		if _, ok := a.cfgTraps[t.Name]; ok {
+			log.Debugf("trap definition %q from %q overridden by the configured one", t.Name, t.file)
+			continue
+		}
+		traps = append(traps, t)
+	}
+	names := make([]string, 0, len(a.cfgTraps))
+	for name := range a.cfgTraps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		traps = append(traps, a.cfgTraps[name])
+	}
+	return traps
+}
+
+// updateTrapFilesTelemetry publishes the trap definition files load status,
+// and deletes the status of the files not in the trap directory anymore.
+func (a *app) updateTrapFilesTelemetry(oldFiles map[string]*trapFileStatus) {
+	a.trapsM.RLock()
+	files := make([]*trapFileStatus, 0, len(a.trapFiles))
+	for _, st := range a.trapFiles {
+		files = append(files, st)
+	}
+	deleted := make([]string, 0)
+	for path := range oldFiles {
+		if _, ok := a.trapFiles[path]; !ok {
+			deleted = append(deleted, path)
+		}
+	}
+	a.trapsM.RUnlock()
+
+	for _, st := range files {
+		updateTelemetryCh(a.tuCh, trapFileTelemPath(st.Path), st)
+	}
+	for _, path := range deleted {
+		deleteTelemetryCh(a.tuCh, trapFileTelemPath(path))
+	}
+}
+
+func trapFileTelemPath(path string) string {
+	return fmt.Sprintf("%s{.path==\"%s\"}", snmpTrapsTrapFilePath, path)
+}
+
+func logTrapsDiff(old, new []*trapDefinition) {
+	oldTraps := make(map[string]*trapDefinition, len(old))
+	for _, t := range old {
+		oldTraps[t.Name] = t
+	}
+	for _, t := range new {
+		ot, ok := oldTraps[t.Name]
+		switch {
+		case !ok:
+			log.Infof("trap definition %q added from %q", t.Name, t.source())
+		case ot.checksum != t.checksum:
+			log.Infof("trap definition %q changed", t.Name)
+		}
+		delete(oldTraps, t.Name)
+	}
+	for name := range oldTraps {
+		log.Infof("trap definition %q removed", name)
+	}
+}
+
+func scanTrapDir(dir string) (map[string]fileInfo, error) {
+	files := make(map[string]fileInfo)
+	err := filepath.WalkDir(dir,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			ext := filepath.Ext(path)
+			if ext != ".yaml" && ext != ".yml" {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files[path] = fileInfo{size: fi.Size(), modTime: fi.ModTime()}
+			return nil
+		})
+	return files, err
+}
+
+func sameFiles(a, b map[string]fileInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, fi := range a {
+		ofi, ok := b[p]
+		if !ok || ofi.size != fi.size || !ofi.modTime.Equal(fi.modTime) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadTraps(t *testing.T) {
+	const (
+		valid = `name: test
+trigger:
+  path: /interface[name=*]/oper-state
+trap:
+  oid: 1.3.6.1.6.3.1.1.5.3
+  bindings:
+    - oid: '".1.3.6.1.2.1.2.2.1.8.1"'
+      type: int
+      value: 2
+`
+		validUpdated = `name: test
+trigger:
+  path: /interface[name=*]/oper-state
+trap:
+  oid: 1.3.6.1.6.3.1.1.5.4
+  bindings:
+    - oid: '".1.3.6.1.2.1.2.2.1.8.1"'
+      type: int
+      value: 2
+`
+		broken = "name: test\ntrap: [\n"
+	)
+	type want struct {
+		status string
+		// index of the version expected to be running, -1 for none
+		running int
+		// the file failed and a previous version is running
+		runningPrevious bool
+	}
+	tests := []struct {
+		name string
+		// successive versions of the trap definition file, loaded in turn
+		versions []string
+		want     want
+	}{
+		{
+			name:     "valid",
+			versions: []string{valid},
+			want:     want{status: "loaded", running: 0},
+		},
+		{
+			name:     "broken_after_valid",
+			versions: []string{valid, broken},
+			want:     want{status: "failed", running: 0, runningPrevious: true},
+		},
+		{
+			name:     "broken_twice_after_valid",
+			versions: []string{valid, broken, broken},
+			want:     want{status: "failed", running: 0, runningPrevious: true},
+		},
+		{
+			name:     "broken_without_previous_version",
+			versions: []string{broken},
+			want:     want{status: "failed", running: -1},
+		},
+		{
+			name:     "fixed_after_broken",
+			versions: []string{valid, broken, validUpdated},
+			want:     want{status: "loaded", running: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "test.yaml")
+			a := New(WithTrapDir(dir))
+			// the definition loaded from each version, nil if it failed
+			loaded := make([]*trapDefinition, len(tt.versions))
+			for i, v := range tt.versions {
+				err := os.WriteFile(path, []byte(v), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = a.loadTraps()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if a.trapFiles[path].Status == "loaded" {
+					loaded[i] = a.fileTraps[0]
+				}
+			}
+			st, ok := a.trapFiles[path]
+			if !ok {
+				t.Fatalf("no load status for %q", path)
+			}
+			if st.Status != tt.want.status || st.RunningPreviousVersion != tt.want.runningPrevious {
+				t.Errorf("got status %q running previous version %v, want %q and %v",
+					st.Status, st.RunningPreviousVersion, tt.want.status, tt.want.runningPrevious)
+			}
+			if (st.Error != "") != (tt.want.status == "failed") {
+				t.Errorf("got error %q with status %q", st.Error, st.Status)
+			}
+			traps := a.getTraps()
+			if tt.want.running < 0 {
+				if len(traps) != 0 {
+					t.Errorf("got %d running trap definition(s), want none", len(traps))
+				}
+				return
+			}
+			if len(traps) != 1 || traps[0] != loaded[tt.want.running] {
+				t.Fatalf("got running trap definitions %v, want version %d", traps, tt.want.running)
+			}
+			if st.TrapDefinition != traps[0].Name {
+				t.Errorf("got status trap definition %q, want %q", st.TrapDefinition, traps[0].Name)
+			}
+		})
+	}
+}
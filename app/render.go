@@ -0,0 +1,61 @@
+package app
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// renderQueueSize is the number of matched events queued
+// to be rendered before new ones are dropped.
+const renderQueueSize = 1024
+
+// renderJob is a trap definition trigger matched by an event,
+// rendered into a notification by the render worker.
+type renderJob struct {
+	t        *trapDefinition
+	tr       *trigger
+	input    map[string]any
+	builtins []any
+}
+
+// enqueueRender queues the job to the render worker without blocking,
+// so that the subscription readers never wait on the tasks gNMI Gets
+// or on the destinations.
+// Without a render queue, as in the offline test and simulate apps,
+// the job is rendered synchronously.
+func (a *app) enqueueRender(ctx context.Context, j *renderJob) {
+	if a.renderCh == nil {
+		a.render(ctx, j)
+		return
+	}
+	select {
+	case a.renderCh <- j:
+	default:
+		log.Errorf("trap %q: render queue is full, dropping notification", j.t.Name)
+	}
+}
+
+// runRenderWorker renders the queued jobs until ctx is done.
+// A single worker renders the jobs so that the notifications are
+// queued to the destinations in the order of the events.
+func (a *app) runRenderWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-a.renderCh:
+			a.render(ctx, j)
+		}
+	}
+}
+
+// render runs the tasks of the job, builds its notification
+// and queues it to the destinations senders.
+func (a *app) render(ctx context.Context, j *renderJob) {
+	err := a.handleTrapSend(ctx, j.t, j.tr, j.input, j.builtins)
+	if err != nil {
+		log.Errorf("failed to build and send trap: %v", err)
+		a.traceStep(j.t, "error", err)
+	}
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestEnqueueRender(t *testing.T) {
+	td := new(trapDefinition)
+	err := yaml.Unmarshal([]byte(`name: test
+trigger:
+  path: /interface[name=*]/oper-state
+trap:
+  oid: 1.3.6.1.6.3.1.1.5.3
+  bindings:
+    - oid: '".1.3.6.1.2.1.2.2.1.8.1"'
+      type: int
+      value: 2
+`), td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := td.parseCode(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		// render queue size, -1 for no queue
+		queueSize int
+		jobs      int
+		// notifications rendered and jobs left queued
+		wantRendered int
+		wantQueued   int
+	}{
+		{
+			name:         "no_queue_renders_synchronously",
+			queueSize:    -1,
+			jobs:         2,
+			wantRendered: 2,
+		},
+		{
+			name:       "queued",
+			queueSize:  4,
+			jobs:       2,
+			wantQueued: 2,
+		},
+		{
+			name:       "full_queue_drops",
+			queueSize:  1,
+			jobs:       3,
+			wantQueued: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered := 0
+			a := newOfflineApp([]*trapDefinition{td}, fixtureGetter{},
+				func(_ *trapDefinition, _ string, v any) {
+					if _, ok := v.(*notification); ok {
+						rendered++
+					}
+				})
+			if tt.queueSize >= 0 {
+				a.renderCh = make(chan *renderJob, tt.queueSize)
+			}
+			for i := 0; i < tt.jobs; i++ {
+				builtins := []any{nil, map[string]any{}, td.Trigger[0].Path, nil, nil, "down"}
+				a.enqueueRender(context.Background(), &renderJob{t: td, tr: td.Trigger[0], builtins: builtins})
+			}
+			if rendered != tt.wantRendered || len(a.renderCh) != tt.wantQueued {
+				t.Errorf("got %d rendered and %d queued, want %d and %d",
+					rendered, len(a.renderCh), tt.wantRendered, tt.wantQueued)
+			}
+		})
+	}
+}
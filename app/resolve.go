@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	resolveInterval = 5 * time.Minute
+	resolveTimeout  = 5 * time.Second
+	// interval at which the source-interface address is read again
+	sourceRefreshInterval = 30 * time.Second
+)
+
+// resolvedAddress is the IP address a destination host resolved to,
+// published as state under the destination.
+type resolvedAddress struct {
+	m    *sync.RWMutex
+	addr string
+}
+
+func newResolvedAddress() *resolvedAddress {
+	return &resolvedAddress{m: new(sync.RWMutex)}
+}
+
+func (r *resolvedAddress) MarshalJSON() ([]byte, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return json.Marshal(r.addr)
+}
+
+func (r *resolvedAddress) set(addr string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.addr = addr
+}
+
+// resolve sets the sender destination IP address,
+// host names are resolved from the sender network namespace.
+func (s *sender) resolve(ctx context.Context) error {
+	host := s.dest.host
+	if net.ParseIP(host) != nil {
+		s.setIP(host)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	addrs, err := nsResolver(s.nsName).LookupHost(ctx, host)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("host %q resolved to no address", host)
+	}
+	s.setIP(addrs[0])
+	return nil
+}
+
+// refreshAddress re-resolves the sender destination host name,
+// the sender sockets are reopened if its address changed.
+func (s *sender) refreshAddress(ctx context.Context) {
+	if s.nsName == "" || net.ParseIP(s.dest.host) != nil {
+		return
+	}
+	oldIP := s.ip
+	err := s.resolve(ctx)
+	if err != nil {
+		log.Errorf("destination %q: failed to resolve %q: %v", s.dest.Address, s.dest.host, err)
+		return
+	}
+	if s.ip != oldIP {
+		log.Infof("destination %q: %q address changed from %s to %s", s.dest.Address, s.dest.host, oldIP, s.ip)
+		s.closeClients()
+	}
+}
+
+func (s *sender) setIP(ip string) {
+	if s.ip == ip {
+		return
+	}
+	s.ip = ip
+	s.dest.ResolvedAddress.set(ip)
+	s.a.updateDestinationTelemetry(s.dest)
+}
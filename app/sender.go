@@ -0,0 +1,359 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	g "github.com/gosnmp/gosnmp"
+	"github.com/openconfig/gnmic/api"
+	"github.com/openconfig/gnmic/formatters"
+	log "github.com/sirupsen/logrus"
+)
+
+// sender is a long-lived SNMP sender bound to a destination.
+// It owns a bounded notification queue served by a single worker.
+// Its sockets are opened once in the destination network-instance namespace
+// and reopened when the namespace changes.
+// Traps and informs use separate SNMP clients since, with SNMPv3,
+// their authoritative engines differ.
+type sender struct {
+	a    *app
+	dest *snmpTrapDestination
+
+	queue chan *notification
+	done  chan struct{}
+	// send the queued notifications before stopping
+	drain *atomic.Bool
+
+	// owned by the worker
+	nsName  string
+	ip      string
+	clients map[bool]*g.GoSNMP // keyed by inform
+	usm     *g.UsmSecurityParameters
+	// source address of the open sockets
+	src string
+	// set when the destination network-instance changes
+	reconnect *atomic.Bool
+}
+
+func newSender(a *app, dest *snmpTrapDestination) *sender {
+	return &sender{
+		a:         a,
+		dest:      dest,
+		queue:     make(chan *notification, dest.queueSize()),
+		done:      make(chan struct{}),
+		drain:     new(atomic.Bool),
+		clients:   make(map[bool]*g.GoSNMP),
+		reconnect: new(atomic.Bool),
+	}
+}
+
+// enqueue adds the notification to the sender queue without blocking,
+// it returns false if the queue is full.
+func (s *sender) enqueue(n *notification) bool {
+	select {
+	case s.queue <- n:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop stops the sender worker, if drain is true,
+// the already queued notifications are sent first.
+func (s *sender) stop(drain bool) {
+	s.drain.Store(drain)
+	close(s.done)
+}
+
+func (s *sender) run(ctx context.Context) {
+	defer s.closeClients()
+	ticker := time.NewTicker(resolveInterval)
+	defer ticker.Stop()
+	// the source-interface address is only checked if it is configured
+	var srcTickerC <-chan time.Time
+	if s.dest.SourceAddress == "" && s.dest.SourceInterface != "" {
+		srcTicker := time.NewTicker(sourceRefreshInterval)
+		defer srcTicker.Stop()
+		srcTickerC = srcTicker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refreshAddress(ctx)
+		case <-srcTickerC:
+			s.refreshSourceAddress(ctx)
+		case <-s.done:
+			if !s.drain.Load() {
+				return
+			}
+			for {
+				select {
+				case n := <-s.queue:
+					s.process(ctx, n)
+				default:
+					return
+				}
+			}
+		case n := <-s.queue:
+			s.process(ctx, n)
+		}
+	}
+}
+
+func (s *sender) process(ctx context.Context, n *notification) {
+	nsName, ok := s.a.destinationNetNS(s.dest)
+	if !ok {
+		log.Warnf("trap %q: destination %q network-instance %q is unknown or not up, dropping notification", n.name, s.dest.Address, s.dest.NetworkInstance)
+		s.dest.Statistics.notificationDropped("network-instance not available")
+		return
+	}
+	if err := s.a.config.fanOut.acquire(ctx); err != nil {
+		log.Warnf("trap %q: destination %q: %v, dropping notification", n.name, s.dest.Address, err)
+		s.dest.Statistics.notificationDropped("stopped")
+		return
+	}
+	defer s.a.config.fanOut.release()
+	s.send(ctx, n, nsName)
+}
+
+// send sends the notification to the sender destination through the
+// network namespace nsName.
+func (s *sender) send(ctx context.Context, n *notification, nsName string) {
+	dest := s.dest
+	if s.reconnect.Swap(false) || nsName != s.nsName {
+		s.closeClients()
+		s.nsName = nsName
+		s.ip = ""
+	}
+	if s.ip == "" {
+		err := s.resolve(ctx)
+		if err != nil {
+			log.Errorf("destination %q: failed to resolve %q: %v", dest.Address, dest.host, err)
+			dest.Statistics.sendError(err)
+			return
+		}
+	}
+	// SNMPv1 has no inform
+	inform := n.pdu.IsInform && dest.Version != "v1"
+	client, ok := s.clients[inform]
+	if !ok {
+		var err error
+		client, err = s.connect(ctx, inform)
+		if err != nil {
+			log.Errorf("failed to connect to destination %q: %v", dest.Address, err)
+			dest.Statistics.sendError(err)
+			return
+		}
+		s.clients[inform] = client
+	}
+	if n.community != "" {
+		client.Community = n.community
+	} else {
+		client.Community = dest.Community
+	}
+	if s.usm != nil && !inform {
+		_, _, s.usm.AuthoritativeEngineTime = s.a.engine.params()
+	}
+	pdu := n.pdu
+	if dest.TrapAddress {
+		pdu.Variables = appendTrapAddress(pdu.Variables, client.Conn.LocalAddr())
+	}
+	if dest.Version == "v1" {
+		var err error
+		pdu, err = toV1Trap(pdu, client.Conn.LocalAddr())
+		if err != nil {
+			log.Errorf("trap %q: failed to translate notification to SNMPv1: %v", n.name, err)
+			dest.Statistics.sendError(err)
+			return
+		}
+	}
+	_, err := client.SendTrap(pdu)
+	switch {
+	case err != nil && inform:
+		log.Errorf("trap %q: inform to destination %q not acknowledged: %v", n.name, dest.Address, err)
+		dest.Statistics.informUnacknowledged(n.name, n.oid, err)
+		// the socket is kept if the destination did not answer
+		if !isInformTimeout(err) {
+			s.closeClient(inform)
+		}
+	case err != nil:
+		log.Errorf("failed to send trap to destination %q: %v", dest.Address, err)
+		dest.Statistics.sendError(err)
+		// the socket is reopened on the next notification
+		s.closeClient(inform)
+	case inform:
+		log.Debugf("trap %q: inform acknowledged by destination %q", n.name, dest.Address)
+		dest.Statistics.informAcknowledged()
+	default:
+		dest.Statistics.trapSent()
+	}
+}
+
+// isInformTimeout returns true if err is the error of an inform
+// not acknowledged after all its retries, as opposed to a socket error.
+func isInformTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded) || strings.HasPrefix(err.Error(), "request timeout")
+}
+
+// connect creates an SNMP client and opens its socket
+// in the sender network namespace.
+func (s *sender) connect(ctx context.Context, inform bool) (*g.GoSNMP, error) {
+	dest := s.dest
+	client := &g.GoSNMP{
+		Target:             s.ip,
+		Port:               dest.port,
+		Community:          dest.Community,
+		Version:            g.Version2c,
+		Retries:            dest.Retries,
+		Timeout:            dest.timeout(),
+		ExponentialTimeout: dest.Backoff != "fixed",
+		MaxOids:            g.MaxOids,
+	}
+	switch dest.Version {
+	case "v1":
+		client.Version = g.Version1
+	case "v3":
+		engineID, engineBoots, engineTime := s.a.engine.params()
+		flags, sp, err := dest.usmParams(engineID, engineBoots, engineTime, inform)
+		if err != nil {
+			return nil, err
+		}
+		client.Version = g.Version3
+		client.SecurityModel = g.UserSecurityModel
+		client.MsgFlags = flags
+		client.SecurityParameters = sp
+		client.ContextName = dest.ContextName
+		if !inform {
+			s.usm = sp
+		}
+	}
+	src, err := s.sourceAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if src != "" {
+		client.LocalAddr = net.JoinHostPort(src, "0")
+	}
+	log.Infof("destination %q: opening socket in NS %q, source address %q", dest.Address, s.nsName, src)
+	err = execInNetNS(s.nsName, client.Connect)
+	if err != nil {
+		return nil, err
+	}
+	s.src = src
+	return client, nil
+}
+
+func (s *sender) closeClient(inform bool) {
+	client, ok := s.clients[inform]
+	if !ok {
+		return
+	}
+	if client.Conn != nil {
+		client.Conn.Close()
+	}
+	delete(s.clients, inform)
+	if !inform {
+		s.usm = nil
+	}
+}
+
+func (s *sender) closeClients() {
+	for inform := range s.clients {
+		s.closeClient(inform)
+	}
+}
+
+// sourceAddress returns the configured source address of the destination,
+// or the first address of its source subinterface, read from the gNMI server.
+func (s *sender) sourceAddress(ctx context.Context) (string, error) {
+	dest := s.dest
+	if dest.SourceAddress != "" {
+		return dest.SourceAddress, nil
+	}
+	if dest.SourceInterface == "" {
+		return "", nil
+	}
+	idx := strings.LastIndex(dest.SourceInterface, ".")
+	if idx < 0 {
+		return "", fmt.Errorf("invalid source-interface %q, expected <interface>.<index>", dest.SourceInterface)
+	}
+	af := "ipv4"
+	if ip := net.ParseIP(s.ip); ip != nil && ip.To4() == nil {
+		af = "ipv6"
+	}
+	req, err := api.NewGetRequest(
+		api.Path(fmt.Sprintf("/interface[name=%s]/subinterface[index=%s]/%s/address",
+			dest.SourceInterface[:idx], dest.SourceInterface[idx+1:], af)),
+		api.EncodingASCII(),
+	)
+	if err != nil {
+		return "", err
+	}
+	rsp, err := s.a.tg.Get(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	evs, err := formatters.GetResponseToEventMsgs(rsp, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, ev := range evs {
+		for k, v := range ev.Tags {
+			if strings.HasSuffix(k, "ip-prefix") {
+				return strings.Split(v, "/")[0], nil
+			}
+		}
+		for k, v := range ev.Values {
+			if vs, ok := v.(string); ok && strings.HasSuffix(k, "/ip-prefix") {
+				return strings.Split(vs, "/")[0], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no %s address found on source-interface %q", af, dest.SourceInterface)
+}
+
+// refreshSourceAddress reads the sender source-interface address again,
+// the sender sockets are reopened if it changed.
+func (s *sender) refreshSourceAddress(ctx context.Context) {
+	if len(s.clients) == 0 {
+		// read when the sockets are opened
+		return
+	}
+	src, err := s.sourceAddress(ctx)
+	if err != nil {
+		log.Errorf("destination %q: failed to read source-interface %q address: %v", s.dest.Address, s.dest.SourceInterface, err)
+		return
+	}
+	if src != s.src {
+		log.Infof("destination %q: source-interface %q address changed from %s to %s", s.dest.Address, s.dest.SourceInterface, s.src, src)
+		s.closeClients()
+	}
+}
+
+// appendTrapAddress returns a copy of the variable bindings with
+// snmpTrapAddress.0 set to the local IPv4 address appended.
+// snmpTrapAddress.0 is an IpAddress, which only holds IPv4 addresses,
+// it is not appended to the notifications sent from an IPv6 address.
+func appendTrapAddress(vars []g.SnmpPDU, local net.Addr) []g.SnmpPDU {
+	udpAddr, ok := local.(*net.UDPAddr)
+	if !ok || udpAddr.IP.To4() == nil {
+		log.Debugf("not adding snmpTrapAddress.0: local address %v is not IPv4", local)
+		return vars
+	}
+	rs := make([]g.SnmpPDU, 0, len(vars)+1)
+	rs = append(rs, vars...)
+	return append(rs, g.SnmpPDU{
+		Name:  snmpTrapAddressOID,
+		Type:  g.IPAddress,
+		Value: udpAddr.IP.To4().String(),
+	})
+}
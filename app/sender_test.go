@@ -0,0 +1,43 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestIsInformTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "retries_exhausted",
+			err:  fmt.Errorf("request timeout (after %d retries)", 3),
+			want: true,
+		},
+		{
+			name: "deadline_exceeded",
+			err:  fmt.Errorf("read udp: %w", os.ErrDeadlineExceeded),
+			want: true,
+		},
+		{
+			name: "connection_refused",
+			err:  fmt.Errorf("write udp: %w", syscall.ECONNREFUSED),
+		},
+		{
+			name: "network_unreachable",
+			err:  errors.New("write udp 10.0.0.1:49152->10.0.0.2:162: sendto: network is unreachable"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isInformTimeout(tt.err); got != tt.want {
+				t.Errorf("isInformTimeout(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itchyny/gojq"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmic/utils"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"gopkg.in/yaml.v2"
+)
+
+// separates the SubscribeResponses of a prototext file
+const prototextSeparator = "---"
+
+// traceFunc is called with each step of a trap definition evaluation:
+// trigger, condition, publish, task <name>, notification and error.
+type traceFunc func(t *trapDefinition, step string, v any)
+
+func (a *app) traceStep(t *trapDefinition, step string, v any) {
+	if a.trace == nil {
+		return
+	}
+	a.trace(t, step, v)
+}
+
+// variable is a variable published by a trigger or a task.
+type variable struct {
+	name  string
+	value any
+}
+
+func publishedVars(publishCode []map[string]*gojq.Code, vals []any) []*variable {
+	rs := make([]*variable, 0, len(vals))
+	i := 0
+	for _, mc := range publishCode {
+		for k := range mc {
+			if i >= len(vals) {
+				return rs
+			}
+			rs = append(rs, &variable{name: k, value: vals[i]})
+			i++
+		}
+	}
+	return rs
+}
+
+// fixtureGetter answers the tasks gNMI Get requests from fixtures:
+// values keyed by gNMI path.
+type fixtureGetter map[string]any
+
+// readFixtures reads a YAML or JSON file mapping gNMI paths to values.
+func readFixtures(file string) (fixtureGetter, error) {
+	f := make(fixtureGetter)
+	if file == "" {
+		return f, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]any)
+	err = yaml.Unmarshal(b, &m)
+	if err != nil {
+		return nil, err
+	}
+	return newFixtureGetter(m)
+}
+
+func newFixtureGetter(m map[string]any) (fixtureGetter, error) {
+	f := make(fixtureGetter, len(m))
+	for p, v := range m {
+		gp, err := utils.ParsePath(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixture path %q: %v", p, err)
+		}
+		f[utils.GnmiPathToXPath(gp, false)] = convertYAML(v)
+	}
+	return f, nil
+}
+
+func (f fixtureGetter) Get(ctx context.Context, req *gnmi.GetRequest) (*gnmi.GetResponse, error) {
+	notif := &gnmi.Notification{
+		Timestamp: time.Now().UnixNano(),
+		Prefix:    req.GetPrefix(),
+	}
+	for _, p := range req.GetPath() {
+		xp := utils.GnmiPathToXPath(&gnmi.Path{Elem: utils.PathElems(req.GetPrefix(), p)}, false)
+		v, ok := f[xp]
+		if !ok {
+			return nil, fmt.Errorf("no fixture for path %q", xp)
+		}
+		tv := new(gnmi.TypedValue)
+		if s, ok := v.(string); ok {
+			tv.Value = &gnmi.TypedValue_AsciiVal{AsciiVal: s}
+		} else {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("fixture for path %q: %v", xp, err)
+			}
+			tv.Value = &gnmi.TypedValue_JsonVal{JsonVal: b}
+		}
+		notif.Update = append(notif.Update, &gnmi.Update{Path: p, Val: tv})
+	}
+	return &gnmi.GetResponse{Notification: []*gnmi.Notification{notif}}, nil
+}
+
+// convertYAML converts the maps decoded by the YAML parser
+// to maps that can be encoded to JSON.
+func convertYAML(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, vv := range v {
+			m[fmt.Sprint(k)] = convertYAML(vv)
+		}
+		return m
+	case map[string]any:
+		for k, vv := range v {
+			v[k] = convertYAML(vv)
+		}
+		return v
+	case []any:
+		for i, vv := range v {
+			v[i] = convertYAML(vv)
+		}
+		return v
+	}
+	return v
+}
+
+// readSubscribeResponses reads gNMI SubscribeResponses from a file,
+// either JSON encoded, as a single object, a list or a stream of objects,
+// or prototext encoded, separated by lines containing "---".
+func readSubscribeResponses(file string) ([]*gnmi.SubscribeResponse, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimSpace(b)
+	rsps := make([]*gnmi.SubscribeResponse, 0)
+	if len(b) == 0 {
+		return rsps, nil
+	}
+	switch b[0] {
+	case '[':
+		msgs := make([]json.RawMessage, 0)
+		err = json.Unmarshal(b, &msgs)
+		if err != nil {
+			return nil, err
+		}
+		for i, msg := range msgs {
+			rsp := new(gnmi.SubscribeResponse)
+			err = protojson.Unmarshal(msg, rsp)
+			if err != nil {
+				return nil, fmt.Errorf("response index %d: %v", i, err)
+			}
+			rsps = append(rsps, rsp)
+		}
+	case '{':
+		dec := json.NewDecoder(bytes.NewReader(b))
+		for i := 0; ; i++ {
+			var msg json.RawMessage
+			err = dec.Decode(&msg)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			rsp := new(gnmi.SubscribeResponse)
+			err = protojson.Unmarshal(msg, rsp)
+			if err != nil {
+				return nil, fmt.Errorf("response index %d: %v", i, err)
+			}
+			rsps = append(rsps, rsp)
+		}
+	default:
+		blocks := make([]string, 0)
+		sb := new(strings.Builder)
+		sc := bufio.NewScanner(bytes.NewReader(b))
+		for sc.Scan() {
+			if strings.TrimSpace(sc.Text()) == prototextSeparator {
+				blocks = append(blocks, sb.String())
+				sb.Reset()
+				continue
+			}
+			sb.WriteString(sc.Text())
+			sb.WriteString("\n")
+		}
+		if err = sc.Err(); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, sb.String())
+		for i, blk := range blocks {
+			if strings.TrimSpace(blk) == "" {
+				continue
+			}
+			rsp := new(gnmi.SubscribeResponse)
+			err = prototext.Unmarshal([]byte(blk), rsp)
+			if err != nil {
+				return nil, fmt.Errorf("response index %d: %v", i, err)
+			}
+			rsps = append(rsps, rsp)
+		}
+	}
+	return rsps, nil
+}
+
+// newOfflineApp returns an app running the trap definitions traps without an NDK agent,
+// a gNMI server or destinations: the notifications are not sent.
+func newOfflineApp(traps []*trapDefinition, getter gnmiGetter, trace traceFunc) *app {
+	return &app{
+		config: &config{
+			m:            &sync.RWMutex{},
+			destinations: map[string]*snmpTrapDestination{},
+			senders:      map[string]*sender{},
+		},
+		trapsM:        &sync.RWMutex{},
+		traps:         traps,
+		getter:        getter,
+		trace:         trace,
+		startTime:     time.Now(),
+		uptimeSource:  UptimeSourceApp,
+		bootTime:      &bootTime{m: &sync.RWMutex{}},
+		engine:        newSNMPEngine(),
+		globals:       newGlobals(),
+		triggerStates: newTriggerStates(),
+	}
+}
+
+// Simulate runs the gNMI SubscribeResponses read from rspFile through the trap definition
+// read from defFile, and writes each step of its evaluation to w.
+// The tasks gNMI Get requests are answered from fixturesFile.
+// Nothing is sent on the network.
+func Simulate(ctx context.Context, defFile, rspFile, fixturesFile string, w io.Writer) error {
+	// libraries are read from the trap definition directory
+	libs, _, err := readLibraries(filepath.Dir(defFile))
+	if err != nil {
+		return fmt.Errorf("failed to read libraries: %v", err)
+	}
+	t, err := readTrapFile(defFile, libs)
+	if err != nil {
+		return fmt.Errorf("failed to load trap definition: %v", err)
+	}
+	if t == nil {
+		return fmt.Errorf("%q is a library file, not a trap definition", defFile)
+	}
+	rsps, err := readSubscribeResponses(rspFile)
+	if err != nil {
+		return fmt.Errorf("failed to read subscribe responses: %v", err)
+	}
+	fixtures, err := readFixtures(fixturesFile)
+	if err != nil {
+		return fmt.Errorf("failed to read task fixtures: %v", err)
+	}
+	var triggered bool
+	a := newOfflineApp([]*trapDefinition{t}, fixtures,
+		func(t *trapDefinition, step string, v any) {
+			triggered = true
+			printStep(w, step, v)
+		})
+	// global variables are read from the trap definition directory
+	// and their values from the fixtures
+	a.trapDir = filepath.Dir(defFile)
+	a.loadGlobals(ctx)
+	if gv := a.globals.get(); len(gv) > 0 {
+		printStep(w, "globals", gv)
+	}
+	for i, rsp := range rsps {
+		fmt.Fprintf(w, "response %d:\n", i)
+		triggered = false
+		a.handleSubscribeResponse(ctx, "", rsp)
+		if !triggered {
+			fmt.Fprintf(w, "  trap %q not triggered\n", t.Name)
+		}
+	}
+	return nil
+}
+
+func printStep(w io.Writer, step string, v any) {
+	switch v := v.(type) {
+	case []*variable:
+		fmt.Fprintf(w, "  %s:\n", step)
+		for _, vr := range v {
+			fmt.Fprintf(w, "    $%s = %s\n", vr.name, jsonString(vr.value))
+		}
+	case *notification:
+		fmt.Fprintf(w, "  %s: %s, inform=%t", step, v.oid, v.pdu.IsInform)
+		if v.community != "" {
+			fmt.Fprintf(w, ", community=%q", v.community)
+		}
+		fmt.Fprintln(w)
+		for _, vb := range v.pdu.Variables {
+			fmt.Fprintf(w, "    %s %s %s\n", vb.Name, vb.Type, jsonString(vb.Value))
+		}
+	default:
+		fmt.Fprintf(w, "  %s: %s\n", step, jsonString(v))
+	}
+}
+
+func jsonString(v any) string {
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// statisticsInterval is the interval at which the destinations
+// statistics are published, if they changed.
+const statisticsInterval = 10 * time.Second
+
+// destinationStats holds the notification counters of an SNMP trap destination,
+// published as state under the destination.
+type destinationStats struct {
+	m *sync.Mutex
+	// set when a counter changed since the statistics were last published
+	changed bool
+
+	TrapsSent             uint64 `json:"traps-sent"`
+	InformsSent           uint64 `json:"informs-sent"`
+	InformsAcknowledged   uint64 `json:"informs-acknowledged"`
+	InformsUnacknowledged uint64 `json:"informs-unacknowledged"`
+	SendErrors            uint64 `json:"send-errors"`
+	Dropped               uint64 `json:"dropped"`
+	Filtered              uint64 `json:"filtered"`
+	LastError             string `json:"last-error,omitempty"`
+	LastErrorTime         string `json:"last-error-time,omitempty"`
+	LastDropReason        string `json:"last-drop-reason,omitempty"`
+	LastDropTime          string `json:"last-drop-time,omitempty"`
+	// filter that matched the last notification
+	LastFilterMatch *filterMatch `json:"last-filter-match,omitempty"`
+	// last inform that was not acknowledged after all retries
+	LastUnacknowledgedInform *unacknowledgedInform `json:"last-unacknowledged-inform,omitempty"`
+}
+
+type unacknowledgedInform struct {
+	Time            string `json:"time,omitempty"`
+	TrapDefinition  string `json:"trap-definition,omitempty"`
+	NotificationOID string `json:"notification-oid,omitempty"`
+}
+
+func newDestinationStats() *destinationStats {
+	return &destinationStats{m: new(sync.Mutex)}
+}
+
+func (s *destinationStats) MarshalJSON() ([]byte, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	type stats destinationStats
+	return json.Marshal((*stats)(s))
+}
+
+func (s *destinationStats) trapSent() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.TrapsSent++
+	s.changed = true
+}
+
+func (s *destinationStats) informAcknowledged() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.InformsSent++
+	s.InformsAcknowledged++
+	s.changed = true
+}
+
+func (s *destinationStats) informUnacknowledged(trapName, oid string, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	s.InformsSent++
+	s.InformsUnacknowledged++
+	s.LastError = err.Error()
+	s.LastErrorTime = now
+	s.LastUnacknowledgedInform = &unacknowledgedInform{
+		Time:            now,
+		TrapDefinition:  trapName,
+		NotificationOID: oid,
+	}
+	s.changed = true
+}
+
+func (s *destinationStats) sendError(err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.SendErrors++
+	s.LastError = err.Error()
+	s.LastErrorTime = time.Now().UTC().Format(time.RFC3339Nano)
+	s.changed = true
+}
+
+func (s *destinationStats) notificationDropped(reason string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.Dropped++
+	s.LastDropReason = reason
+	s.LastDropTime = time.Now().UTC().Format(time.RFC3339Nano)
+	s.changed = true
+}
+
+func (s *destinationStats) filterMatched(m *filterMatch) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if m.Action == "drop" {
+		s.Filtered++
+	}
+	s.LastFilterMatch = m
+	s.changed = true
+}
+
+// swapChanged returns true if the statistics changed
+// since it was last called.
+func (s *destinationStats) swapChanged() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	changed := s.changed
+	s.changed = false
+	return changed
+}
+
+// publishStatistics periodically publishes the state of the destinations
+// whose statistics changed, the counters are updated for each notification
+// but published at most once per statisticsInterval.
+func (a *app) publishStatistics(ctx context.Context) {
+	ticker := time.NewTicker(statisticsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// the config lock is held so that the state of
+			// a deleted destination is not published again
+			a.config.m.RLock()
+			for _, dest := range a.config.destinations {
+				if dest.Statistics.swapChanged() {
+					a.updateDestinationTelemetry(dest)
+				}
+			}
+			a.config.m.RUnlock()
+		}
+	}
+}
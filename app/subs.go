@@ -2,10 +2,9 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"runtime"
-	"sync"
-	"time"
+	"strings"
 
 	"context"
 
@@ -15,132 +14,254 @@ import (
 	"github.com/openconfig/gnmic/api"
 	"github.com/openconfig/gnmic/formatters"
 	log "github.com/sirupsen/logrus"
-	"github.com/vishvananda/netns"
-	"golang.org/x/sync/semaphore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/prototext"
 )
 
 const (
 	sysUpTimeInstanceOID = "1.3.6.1.2.1.1.3.0"
+	snmpTrapOID          = "1.3.6.1.6.3.1.1.4.1.0"
+	snmpTrapAddressOID   = "1.3.6.1.6.3.18.1.3.0"
 )
 
+// StartSubscriptions subscribes to the trap definitions trigger paths
+// and handles the received notifications.
 func (a *app) StartSubscriptions(ctx context.Context) {
-	opts := []api.GNMIOption{
-		api.EncodingASCII(),
-		api.SubscriptionListModeSTREAM(),
-	}
-
-	for _, p := range a.getTriggersPaths() {
-		opts = append(opts,
-			api.Subscription(
-				api.SubscriptionModeON_CHANGE(),
-				api.Path(p),
-			),
-		)
-	}
-
-	subscribeRequest, err := api.NewSubscribeRequest(opts...)
-	if err != nil {
-		log.Errorf("failed to create a subscription request: %v", err)
-		return
-	}
-
-	log.Debugf("subscribe request:\n%s", prototext.Format(subscribeRequest))
-
-SUB:
-	nctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	go a.tg.Subscribe(nctx, subscribeRequest, "traps")
+	a.updateSubscriptions(ctx)
 
 	rspCh, errCh := a.tg.ReadSubscriptions()
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case rsp, ok := <-rspCh:
 			if !ok {
 				return
 			}
-			log.Debugf("got subscription notification: %v", rsp.Response)
-			a.handleSubscribeResponse(ctx, rsp.Response)
+			log.Debugf("got subscription %q notification: %v", rsp.SubscriptionName, rsp.Response)
+			a.handleSubscribeResponse(ctx, rsp.SubscriptionName, rsp.Response)
 		case err, ok := <-errCh:
 			if !ok {
 				return
 			}
-			if err != nil {
-				log.Errorf("subscription failed: %v", err)
-				cancel()
-				goto SUB
+			if err == nil || err.Err == nil || isCanceled(err.Err) || !a.subscribed(err.SubscriptionName) {
+				// stopped subscriptions errors are expected
+				continue
 			}
+			// the target retries failed subscriptions
+			log.Errorf("subscription %q failed: %v", err.SubscriptionName, err.Err)
 		}
 	}
 }
 
-func (a *app) getTriggersPaths() []string {
-	p := make([]string, 0, len(a.traps))
-	for _, t := range a.traps {
-		p = append(p, t.Trigger.Path)
+// updateSubscriptions starts a STREAM subscription per trigger path and mode,
+// and per global variable path, and stops the subscriptions no longer used.
+// The running subscriptions are kept as is, so that a change in
+// the trap definitions does not resync the paths that did not change.
+func (a *app) updateSubscriptions(ctx context.Context) {
+	a.subs.m.Lock()
+	defer a.subs.m.Unlock()
+	subs := a.subscriptionPaths()
+	for name, cancel := range a.subs.cancelFns {
+		if _, ok := subs[name]; ok {
+			continue
+		}
+		log.Infof("stopping subscription %q", name)
+		cancel()
+		delete(a.subs.cancelFns, name)
 	}
-	return p
+	for name, sp := range subs {
+		if _, ok := a.subs.cancelFns[name]; ok {
+			continue
+		}
+		subscribeRequest, err := api.NewSubscribeRequest(
+			api.EncodingASCII(),
+			api.SubscriptionListModeSTREAM(),
+			api.Subscription(append(sp.mode.options(), api.Path(sp.path))...),
+		)
+		if err != nil {
+			log.Errorf("failed to create subscription request %q: %v", name, err)
+			continue
+		}
+		log.Infof("starting subscription %q", name)
+		log.Debugf("subscribe request:\n%s", prototext.Format(subscribeRequest))
+		nctx, cancel := context.WithCancel(ctx)
+		a.subs.cancelFns[name] = cancel
+		go a.tg.Subscribe(nctx, subscribeRequest, name)
+	}
+}
+
+// subscribed returns true if the subscription name is running.
+func (a *app) subscribed(name string) bool {
+	a.subs.m.Lock()
+	defer a.subs.m.Unlock()
+	_, ok := a.subs.cancelFns[name]
+	return ok
+}
+
+// isCanceled returns true if err is the error of a canceled subscription.
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || status.Code(err) == codes.Canceled
+}
+
+// subscriptionPath is a path subscribed to with a mode.
+type subscriptionPath struct {
+	mode *subscriptionMode
+	path string
 }
 
-func (a *app) handleSubscribeResponse(ctx context.Context, rsp *gnmi.SubscribeResponse) {
+// subscriptionName returns the name of the subscription to path p with the mode m.
+func subscriptionName(m *subscriptionMode, p string) string {
+	return m.name() + " " + p
+}
+
+// subscriptionPaths returns the subscriptions of the running trap definitions
+// triggers and of the global variables, by name.
+// disabled trap definitions are not running.
+func (a *app) subscriptionPaths() map[string]*subscriptionPath {
+	subs := make(map[string]*subscriptionPath)
+	for _, t := range a.getTraps() {
+		for _, tr := range t.Trigger {
+			subs[tr.subscription] = &subscriptionPath{mode: &tr.subscriptionMode, path: tr.Path}
+		}
+	}
+	// global variables are refreshed on change
+	for _, p := range a.globals.paths() {
+		m := new(subscriptionMode)
+		subs[subscriptionName(m, p)] = &subscriptionPath{mode: m, path: p}
+	}
+	return subs
+}
+
+// handleSubscribeResponse handles a response of the subscription sub,
+// the triggers subscribed with another subscription are skipped.
+// An empty sub matches all the triggers.
+func (a *app) handleSubscribeResponse(ctx context.Context, sub string, rsp *gnmi.SubscribeResponse) {
 	evs, err := formatters.ResponseToEventMsgs("", rsp, nil)
 	if err != nil {
 		log.Errorf("failed to convert subscribe response to event: %v", err)
 		return
 	}
-	for _, t := range a.traps {
+	for _, ev := range evs {
+		if gv := a.globals.valuesFromEvent(ev); len(gv) > 0 {
+			log.Debugf("globals update: %v", gv)
+			a.globals.set(gv)
+		}
+	}
+	for _, t := range a.getTraps() {
 		for _, ev := range evs {
-			if _, ok := ev.Values[t.Trigger.Path]; !ok {
-				continue
-			}
-			input := ev.ToMap()
-			if t.Trigger.conditionCode != nil {
-				v, err := runJQ(t.Trigger.conditionCode, input)
-				if err != nil {
-					log.Errorf("trap %q: failed to evaluate trigger condition: %v", t.Name, err)
-					continue
-				}
-				vb, ok := v.(bool)
-				if !ok {
-					log.Errorf("trap %q: unexpected condition result type, wanted boolean, got %T", t.Name, v)
-					continue
-				}
-				if !vb {
-					continue
-				}
-			}
-			log.Debugf("event matched trap %q. event=%v", t.Name, ev)
-			// handle matched ev and trap
-			err = a.handleTrapSend(ctx, t, input)
-			if err != nil {
-				log.Errorf("failed to build and send trap: %v", err)
+			if len(ev.Deletes) > 0 {
+				a.deleteTriggerStates(t, sub, ev)
 			}
+			a.handleTrapEvent(ctx, t, sub, ev)
 		}
 	}
 }
 
-func (a *app) handleTrapSend(ctx context.Context, t *trapDefinition, input map[string]any) error {
-	pdus := make([]g.SnmpPDU, 0, len(t.TrapPDU.Bindings)+1)
+// handleTrapEvent builds and sends the trap of definition t
+// if the event matches one of its triggers.
+// Only the first trigger matching the event and its condition fires,
+// the states of the following matching triggers are still updated.
+func (a *app) handleTrapEvent(ctx context.Context, t *trapDefinition, sub string, ev *formatters.EventMsg) {
+	var input map[string]any
+	globalsVals := a.globals.get()
+	fired := false
+	for idx, tr := range t.Trigger {
+		if sub != "" && sub != tr.subscription {
+			continue
+		}
+		keys, ok := tr.match(ev)
+		if !ok {
+			continue
+		}
+		if input == nil {
+			input = ev.ToMap()
+		}
+		a.traceStep(t, "trigger "+tr.Path, input)
+		if len(keys) > 0 {
+			a.traceStep(t, "keys", keys)
+		}
+		// values of builtinVars: $globals, $keys, $trigger, $threshold, $previous and $current
+		current := ev.Values[tr.schemaPath]
+		builtins := []any{globalsVals, keys, tr.Path, nil, nil, current}
+		previous, alarm, fire, err := a.updateTriggerState(t, idx, ev.Tags, input, builtins)
+		if err != nil {
+			log.Errorf("trap %q: %v", t.Name, err)
+			a.traceStep(t, "error", err)
+			continue
+		}
+		if previous != nil {
+			a.traceStep(t, "previous", previous)
+		}
+		if alarm != nil {
+			a.traceStep(t, "threshold", alarm)
+		}
+		if !fire || fired {
+			continue
+		}
+		builtins[3], builtins[4] = alarm, previous
+		if !a.triggerCondition(t, tr, input, builtins) {
+			continue
+		}
+		a.recordAlarm(t, idx, ev.Tags, alarm)
+		log.Debugf("event matched trap %q trigger %q. event=%v", t.Name, tr.Path, ev)
+		// the tasks and the sending run off the subscription reader
+		a.enqueueRender(ctx, &renderJob{t: t, tr: tr, input: input, builtins: builtins})
+		fired = true
+	}
+}
+
+// triggerCondition returns true if the trigger has no condition,
+// or if its condition evaluates to true.
+func (a *app) triggerCondition(t *trapDefinition, tr *trigger, input map[string]any, builtins []any) bool {
+	if tr.conditionCode == nil {
+		return true
+	}
+	v, err := runJQ(tr.conditionCode, input, builtins...)
+	if err != nil {
+		log.Errorf("trap %q: failed to evaluate trigger condition: %v", t.Name, err)
+		a.traceStep(t, "error", err)
+		return false
+	}
+	vb, ok := v.(bool)
+	if !ok {
+		log.Errorf("trap %q: unexpected condition result type, wanted boolean, got %T", t.Name, v)
+		a.traceStep(t, "error", fmt.Errorf("unexpected condition result type, wanted boolean, got %T", v))
+		return false
+	}
+	a.traceStep(t, "condition", vb)
+	return vb
+}
+
+func (a *app) handleTrapSend(ctx context.Context, t *trapDefinition, tr *trigger, input map[string]any, builtins []any) error {
+	pdus := make([]g.SnmpPDU, 0, len(t.TrapPDU.Bindings)+2)
 
 	// append systemUptime pdu
 	pdus = append(pdus, g.SnmpPDU{
 		Name:  sysUpTimeInstanceOID,
 		Type:  g.TimeTicks,
-		Value: uint32(time.Since(a.startTime).Seconds()),
+		Value: a.sysUpTime(),
 	})
 	// run trigger publish
-	varsVals, err := a.triggerPublish(t.Trigger, input)
+	rs, err := a.triggerPublish(tr, input, builtins)
 	if err != nil {
 		return err
 	}
-	log.Debugf("trap %q: trigger published vars: %v", t.Name, varsVals)
+	log.Debugf("trap %q: trigger published vars: %v", t.Name, rs)
+	a.traceStep(t, "publish", publishedVars(tr.publishCode, rs))
+	// the builtin variables are followed by the variables published by the triggers
+	varsVals := make([]any, 0, len(builtins)+len(t.triggerVars))
+	varsVals = append(varsVals, builtins...)
+	varsVals = append(varsVals, t.triggerVarsValues(tr, rs)...)
 
 	for _, tsk := range t.Tasks {
-		rs, err := tsk.run(ctx, a.tg, varsVals...)
+		rs, err := tsk.run(ctx, a.getter, varsVals...)
 		if err != nil {
 			return err
 		}
 		log.Debugf("trap %q: task %q vars: %v", t.Name, tsk.Name, rs)
+		a.traceStep(t, "task "+tsk.Name, publishedVars(tsk.publishCode, rs))
 		varsVals = append(varsVals, rs...)
 	}
 	//
@@ -157,6 +278,17 @@ func (a *app) handleTrapSend(ctx context.Context, t *trapDefinition, input map[s
 		}
 	}
 	log.Debugf("trap %q: community: %q", t.Name, trapCommunity)
+	// append snmpTrapOID pdu
+	notificationOID, err := t.TrapPDU.notificationOID(varsVals...)
+	if err != nil {
+		return err
+	}
+	log.Debugf("trap %q: notification OID: %q", t.Name, notificationOID)
+	pdus = append(pdus, g.SnmpPDU{
+		Name:  snmpTrapOID,
+		Type:  g.ObjectIdentifier,
+		Value: notificationOID,
+	})
 	// build trap PDU
 	for _, bind := range t.TrapPDU.Bindings {
 		oid, err := runJQ(bind.oidCode, nil, varsVals...)
@@ -176,25 +308,50 @@ func (a *app) handleTrapSend(ctx context.Context, t *trapDefinition, input map[s
 	}
 
 	// send trap PDU
-	trapPDU := g.SnmpTrap{
-		Variables: pdus,
-		IsInform:  t.TrapPDU.InformPDU,
+	n := &notification{
+		name:      t.Name,
+		tags:      t.Tags,
+		oid:       notificationOID,
+		community: trapCommunity,
+		pdu: g.SnmpTrap{
+			Variables: pdus,
+			IsInform:  t.TrapPDU.InformPDU,
+		},
 	}
 	// debug
 	if log.GetLevel() > log.DebugLevel {
-		b, _ := json.MarshalIndent(trapPDU.Variables, "", "  ")
+		b, _ := json.MarshalIndent(n.pdu.Variables, "", "  ")
 		log.Debugf("trapPDU variables:\n%s", string(b))
 	}
 	//
-	a.sendTrap(trapPDU, trapCommunity)
+	a.traceStep(t, "notification", n)
+	a.sendTrap(n)
 	return nil
 }
 
-func (a *app) triggerPublish(t *trigger, input map[string]interface{}) ([]any, error) {
+func (tp *trapPDU) notificationOID(vars ...any) (string, error) {
+	if tp.oidCode == nil {
+		return strings.TrimSpace(tp.OID), nil
+	}
+	r, err := runJQ(tp.oidCode, nil, vars...)
+	if err != nil {
+		return "", err
+	}
+	oid, ok := r.(string)
+	if !ok {
+		return "", fmt.Errorf("resulting notification OID is not a string: %v", r)
+	}
+	if !isOID(oid) {
+		return "", fmt.Errorf("resulting notification OID is not a valid OID: %q", oid)
+	}
+	return oid, nil
+}
+
+func (a *app) triggerPublish(t *trigger, input map[string]interface{}, builtins []any) ([]any, error) {
 	rs := make([]any, 0, len(t.publishCode))
 	for _, mv := range t.publishCode {
 		for _, c := range mv {
-			r, err := runJQ(c, input)
+			r, err := runJQ(c, input, builtins...)
 			if err != nil {
 				return nil, err
 			}
@@ -261,66 +418,40 @@ func pduType(typ string) g.Asn1BER {
 	return g.UnknownType
 }
 
-func (a *app) sendTrap(trapPDU g.SnmpTrap, trapCommunity string) {
-	wg := new(sync.WaitGroup)
-	sem := semaphore.NewWeighted(1)
-	for _, dest := range a.config.destinations {
+// notification is a trap PDU built from a trap definition,
+// ready to be sent to the configured destinations.
+type notification struct {
+	name      string
+	tags      []string
+	oid       string
+	community string
+	pdu       g.SnmpTrap
+}
+
+// sendTrap queues the notification to the enabled destinations senders.
+// it never blocks, notifications are dropped if a destination queue is full.
+func (a *app) sendTrap(n *notification) {
+	a.config.m.RLock()
+	defer a.config.m.RUnlock()
+	for addr, dest := range a.config.destinations {
 		if dest.AdminState != "enable" {
 			continue
 		}
-		wg.Add(1)
-		sem.Acquire(context.TODO(), 1)
-		go func(dest *snmpTrapDestination) {
-			defer wg.Done()
-			defer sem.Release(1)
-			// netwIns
-			var netInstName string
-			a.config.m.RLock()
-			netInst, ok := a.config.nwInst[dest.NetworkInstance]
-			a.config.m.RUnlock()
-			if !ok {
-				log.Errorf("unknown network instance name: %s", dest.NetworkInstance)
-				return
-			}
-			if !netInst.OperIsUp {
-				log.Debugf("destination %q: network instance %q is not oper UP", dest.Address, dest.NetworkInstance)
-				return
-			}
-
-			netInstName = fmt.Sprintf("%s-%s", netInst.BaseName, dest.NetworkInstance)
-			n, err := netns.GetFromName(netInstName)
-			if err != nil {
-				log.Errorf("failed getting NS %q: %v", netInstName, err)
-				return
-			}
-			runtime.LockOSThread()
-			defer runtime.UnlockOSThread()
-			err = netns.Set(n)
-			if err != nil {
-				log.Infof("failed setting NS to %s: %v", n, err)
-				return
-			}
-			// init client and send trap
-			snmpClient := g.NewHandler()
-			snmpClient.SetTarget(dest.ip)
-			if trapCommunity != "" {
-				snmpClient.SetCommunity(trapCommunity)
-			} else {
-				snmpClient.SetCommunity(dest.Community)
-			}
-			snmpClient.SetPort(dest.port)
-			snmpClient.SetVersion(g.Version2c)
-			err = snmpClient.Connect()
-			if err != nil {
-				log.Errorf("failed to connect to destination %q: %v", dest.Address, err)
-				return
-			}
-			_, err = snmpClient.SendTrap(trapPDU)
-			if err != nil {
-				log.Errorf("failed to send trap to destination %q: %v", dest.Address, err)
-				return
-			}
-		}(dest)
+		s, ok := a.config.senders[addr]
+		if !ok {
+			continue
+		}
+		send, m := dest.filter(n)
+		if m != nil {
+			log.Debugf("trap %q: destination %q filter match: %+v", n.name, addr, m)
+			dest.Statistics.filterMatched(m)
+		}
+		if !send {
+			continue
+		}
+		if !s.enqueue(n) {
+			log.Errorf("trap %q: destination %q queue is full, dropping notification", n.name, addr)
+			dest.Statistics.notificationDropped("queue full")
+		}
 	}
-	wg.Wait()
 }
@@ -3,12 +3,18 @@ package app
 import (
 	"context"
 
+	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/openconfig/gnmic/api"
 	"github.com/openconfig/gnmic/formatters"
-	"github.com/openconfig/gnmic/target"
 )
 
-func (tsk *task) run(ctx context.Context, tg *target.Target, vars ...any) ([]any, error) {
+// gnmiGetter answers the tasks gNMI Get requests,
+// it is the gNMI target, or fixtures when simulating a trap definition.
+type gnmiGetter interface {
+	Get(ctx context.Context, req *gnmi.GetRequest) (*gnmi.GetResponse, error)
+}
+
+func (tsk *task) run(ctx context.Context, tg gnmiGetter, vars ...any) ([]any, error) {
 	var ev *formatters.EventMsg
 	var err error
 	if tsk.GNMI != nil {
@@ -31,7 +37,7 @@ func (tsk *task) run(ctx context.Context, tg *target.Target, vars ...any) ([]any
 	return rs, nil
 }
 
-func (tsk *task) runGNMI(ctx context.Context, tg *target.Target, vars ...any) (*formatters.EventMsg, error) {
+func (tsk *task) runGNMI(ctx context.Context, tg gnmiGetter, vars ...any) (*formatters.EventMsg, error) {
 	opts := []api.GNMIOption{
 		api.Encoding(tsk.GNMI.Encoding),
 	}
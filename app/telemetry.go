@@ -87,3 +87,10 @@ func updateTelemetryCh(ch chan *telemUpdate, p string, d any) {
 		jsData: string(jsData),
 	}
 }
+
+func deleteTelemetryCh(ch chan *telemUpdate, p string) {
+	ch <- &telemUpdate{
+		op:     "delete",
+		jsPath: p,
+	}
+}
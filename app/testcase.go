@@ -0,0 +1,206 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	g "github.com/gosnmp/gosnmp"
+	"github.com/openconfig/gnmic/formatters"
+)
+
+// trapTest is a trap definition test case: an input event, the mocked
+// responses to the tasks gNMI Get requests and the expected trap.
+type trapTest struct {
+	Name string `yaml:"name,omitempty"`
+	// event triggering the trap, as received from the subscription
+	Event *testEvent `yaml:"event,omitempty"`
+	// events preceding the event, for the triggers with a state
+	// such as thresholds. Their traps are not checked.
+	Events []*testEvent `yaml:"events,omitempty"`
+	// tasks gNMI Get responses values keyed by path
+	Responses map[string]any `yaml:"responses,omitempty"`
+	// global variables values by name
+	Globals map[string]any `yaml:"globals,omitempty"`
+	Expect  *testExpect    `yaml:"expect,omitempty"`
+}
+
+type testEvent struct {
+	Tags   map[string]string `yaml:"tags,omitempty"`
+	Values map[string]any    `yaml:"values,omitempty"`
+}
+
+type testExpect struct {
+	// no trap is expected
+	NoTrap bool `yaml:"no-trap,omitempty"`
+	// notification OID
+	OID string `yaml:"oid,omitempty"`
+	// variable bindings following sysUpTime.0 and snmpTrapOID.0
+	Varbinds []*testVarbind `yaml:"varbinds,omitempty"`
+}
+
+type testVarbind struct {
+	OID   string `yaml:"oid,omitempty"`
+	Type  string `yaml:"type,omitempty"`
+	Value any    `yaml:"value,omitempty"`
+}
+
+// RunTests runs the test cases of the trap definitions under dir
+// and writes their result to w.
+// It returns false if a test case failed or a definition failed to load.
+// The returned error is only set if dir can't be walked.
+func RunTests(ctx context.Context, dir string, w io.Writer) (bool, error) {
+	traps, fileErrs, err := readTrapsDefinition(dir)
+	if err != nil {
+		return false, err
+	}
+	ok := true
+	files := make([]string, 0, len(fileErrs))
+	for f := range fileErrs {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		ok = false
+		fmt.Fprintf(w, "FAIL %s: %v\n", f, fileErrs[f])
+	}
+	// the test cases globals values are checked against their definitions
+	globalDefs, _, _, err := readGlobals(dir)
+	if err != nil {
+		ok = false
+		fmt.Fprintf(w, "FAIL %s: %v\n", dir, err)
+	}
+	var passed, failed int
+	for _, t := range traps {
+		for idx, tc := range t.Tests {
+			name := tc.Name
+			if name == "" {
+				name = fmt.Sprintf("index %d", idx)
+			}
+			diffs := t.runTest(ctx, tc, globalDefs)
+			if len(diffs) == 0 {
+				passed++
+				fmt.Fprintf(w, "PASS %s/%s\n", t.Name, name)
+				continue
+			}
+			ok = false
+			failed++
+			fmt.Fprintf(w, "FAIL %s/%s\n", t.Name, name)
+			for _, d := range diffs {
+				fmt.Fprintf(w, "    %s\n", d)
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d passed, %d failed\n", passed, failed)
+	return ok, nil
+}
+
+// runTest runs the test case tc against the trap definition,
+// with the global variables defined by globalDefs.
+// it returns the differences between the expected and the resulting trap.
+func (t *trapDefinition) runTest(ctx context.Context, tc *trapTest, globalDefs map[string]*globalDefinition) []string {
+	if tc.Event == nil {
+		return []string{"missing \"event\""}
+	}
+	if tc.Expect == nil {
+		return []string{"missing \"expect\""}
+	}
+	getter, err := newFixtureGetter(tc.Responses)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var n *notification
+	errs := make([]string, 0)
+	a := newOfflineApp([]*trapDefinition{t}, getter,
+		func(t *trapDefinition, step string, v any) {
+			switch v := v.(type) {
+			case *notification:
+				n = v
+			case error:
+				errs = append(errs, fmt.Sprintf("%s: %v", step, v))
+			}
+		})
+	a.globals.defs = globalDefs
+	values := make(map[string]any, len(tc.Globals))
+	for k, v := range tc.Globals {
+		if _, ok := globalDefs[k]; !ok {
+			return []string{fmt.Sprintf("globals: %q is not a defined global variable", k)}
+		}
+		values[k] = convertYAML(v)
+	}
+	a.globals.set(values)
+	for _, ev := range tc.Events {
+		a.handleTrapEvent(ctx, t, "", ev.eventMsg())
+	}
+	n = nil
+	a.handleTrapEvent(ctx, t, "", tc.Event.eventMsg())
+	if len(errs) > 0 {
+		return errs
+	}
+	return tc.Expect.diff(n)
+}
+
+func (e *testEvent) eventMsg() *formatters.EventMsg {
+	values := make(map[string]any, len(e.Values))
+	for k, v := range e.Values {
+		values[k] = convertYAML(v)
+	}
+	return &formatters.EventMsg{
+		Tags:   e.Tags,
+		Values: values,
+	}
+}
+
+func (e *testExpect) diff(n *notification) []string {
+	if e.NoTrap {
+		if n != nil {
+			return []string{fmt.Sprintf("expected no trap, got notification %s", n.oid)}
+		}
+		return nil
+	}
+	if n == nil {
+		return []string{"expected a trap, got none"}
+	}
+	diffs := make([]string, 0)
+	if e.OID != "" && normalizeOID(e.OID) != normalizeOID(n.oid) {
+		diffs = append(diffs, fmt.Sprintf("oid: expected %s, got %s", e.OID, n.oid))
+	}
+	// skip sysUpTime.0 and snmpTrapOID.0
+	vbs := n.pdu.Variables
+	if len(vbs) >= 2 {
+		vbs = vbs[2:]
+	}
+	for i := 0; i < len(e.Varbinds) || i < len(vbs); i++ {
+		switch {
+		case i >= len(vbs):
+			diffs = append(diffs, fmt.Sprintf("varbind %d: - %s", i, e.Varbinds[i]))
+		case i >= len(e.Varbinds):
+			diffs = append(diffs, fmt.Sprintf("varbind %d: + %s", i, formatVarbind(vbs[i])))
+		case !e.Varbinds[i].match(vbs[i]):
+			diffs = append(diffs,
+				fmt.Sprintf("varbind %d: - %s", i, e.Varbinds[i]),
+				fmt.Sprintf("varbind %d: + %s", i, formatVarbind(vbs[i])))
+		}
+	}
+	return diffs
+}
+
+func (v *testVarbind) match(vb g.SnmpPDU) bool {
+	return normalizeOID(v.OID) == normalizeOID(vb.Name) &&
+		pduType(v.Type) == vb.Type &&
+		fmt.Sprint(v.Value) == fmt.Sprint(vb.Value)
+}
+
+func (v *testVarbind) String() string {
+	return fmt.Sprintf("%s %s %v", v.OID, pduType(v.Type), v.Value)
+}
+
+func formatVarbind(vb g.SnmpPDU) string {
+	return fmt.Sprintf("%s %s %v", vb.Name, vb.Type, vb.Value)
+}
+
+func normalizeOID(oid string) string {
+	return strings.TrimPrefix(strings.TrimSpace(oid), ".")
+}
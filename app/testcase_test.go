@@ -0,0 +1,128 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+func TestTestExpectDiff(t *testing.T) {
+	newNotification := func(oid string, vbs ...g.SnmpPDU) *notification {
+		vars := []g.SnmpPDU{
+			{Name: "." + sysUpTimeInstanceOID, Type: g.TimeTicks, Value: uint32(100)},
+			{Name: "." + snmpTrapOID, Type: g.ObjectIdentifier, Value: oid},
+		}
+		return &notification{
+			oid: oid,
+			pdu: g.SnmpTrap{Variables: append(vars, vbs...)},
+		}
+	}
+	operStatus := g.SnmpPDU{Name: ".1.3.6.1.2.1.2.2.1.8.1", Type: g.Integer, Value: 2}
+	ifDescr := g.SnmpPDU{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: g.OctetString, Value: "ethernet-1/1"}
+
+	tests := []struct {
+		name   string
+		expect *testExpect
+		n      *notification
+		want   []string
+	}{
+		{
+			name: "match",
+			expect: &testExpect{
+				OID: "1.3.6.1.6.3.1.1.5.3",
+				Varbinds: []*testVarbind{
+					{OID: "1.3.6.1.2.1.2.2.1.8.1", Type: "int", Value: 2},
+					{OID: ".1.3.6.1.2.1.2.2.1.2.1", Type: "octetString", Value: "ethernet-1/1"},
+				},
+			},
+			n:    newNotification(".1.3.6.1.6.3.1.1.5.3", operStatus, ifDescr),
+			want: []string{},
+		},
+		{
+			name:   "oid_not_checked_when_empty",
+			expect: &testExpect{},
+			n:      newNotification("1.3.6.1.6.3.1.1.5.3"),
+			want:   []string{},
+		},
+		{
+			name:   "no_trap_expected",
+			expect: &testExpect{NoTrap: true},
+			n:      nil,
+			want:   nil,
+		},
+		{
+			name:   "unexpected_trap",
+			expect: &testExpect{NoTrap: true},
+			n:      newNotification("1.3.6.1.6.3.1.1.5.3"),
+			want:   []string{"expected no trap, got notification 1.3.6.1.6.3.1.1.5.3"},
+		},
+		{
+			name:   "missing_trap",
+			expect: &testExpect{OID: "1.3.6.1.6.3.1.1.5.3"},
+			n:      nil,
+			want:   []string{"expected a trap, got none"},
+		},
+		{
+			name:   "oid_mismatch",
+			expect: &testExpect{OID: "1.3.6.1.6.3.1.1.5.4"},
+			n:      newNotification("1.3.6.1.6.3.1.1.5.3"),
+			want:   []string{"oid: expected 1.3.6.1.6.3.1.1.5.4, got 1.3.6.1.6.3.1.1.5.3"},
+		},
+		{
+			name: "value_mismatch",
+			expect: &testExpect{
+				Varbinds: []*testVarbind{
+					{OID: "1.3.6.1.2.1.2.2.1.8.1", Type: "int", Value: 1},
+				},
+			},
+			n: newNotification("1.3.6.1.6.3.1.1.5.3", operStatus),
+			want: []string{
+				"varbind 0: - 1.3.6.1.2.1.2.2.1.8.1 Integer 1",
+				"varbind 0: + .1.3.6.1.2.1.2.2.1.8.1 Integer 2",
+			},
+		},
+		{
+			name: "type_mismatch",
+			expect: &testExpect{
+				Varbinds: []*testVarbind{
+					{OID: "1.3.6.1.2.1.2.2.1.8.1", Type: "gauge32", Value: 2},
+				},
+			},
+			n: newNotification("1.3.6.1.6.3.1.1.5.3", operStatus),
+			want: []string{
+				"varbind 0: - 1.3.6.1.2.1.2.2.1.8.1 Gauge32 2",
+				"varbind 0: + .1.3.6.1.2.1.2.2.1.8.1 Integer 2",
+			},
+		},
+		{
+			name: "missing_varbind",
+			expect: &testExpect{
+				Varbinds: []*testVarbind{
+					{OID: "1.3.6.1.2.1.2.2.1.8.1", Type: "int", Value: 2},
+					{OID: "1.3.6.1.2.1.2.2.1.2.1", Type: "octetString", Value: "ethernet-1/1"},
+				},
+			},
+			n:    newNotification("1.3.6.1.6.3.1.1.5.3", operStatus),
+			want: []string{"varbind 1: - 1.3.6.1.2.1.2.2.1.2.1 OctetString ethernet-1/1"},
+		},
+		{
+			name: "extra_varbind",
+			expect: &testExpect{
+				Varbinds: []*testVarbind{
+					{OID: "1.3.6.1.2.1.2.2.1.8.1", Type: "int", Value: 2},
+				},
+			},
+			n:    newNotification("1.3.6.1.6.3.1.1.5.3", operStatus, ifDescr),
+			want: []string{"varbind 1: + .1.3.6.1.2.1.2.2.1.2.1 OctetString ethernet-1/1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.expect.diff(tt.n)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// thresholdVar is the jq variable holding the threshold alarm
+// of the trigger that fired, null for triggers without threshold.
+const thresholdVar = "$threshold"
+
+const (
+	sampleTypeAbsolute = "absolute"
+	sampleTypeDelta    = "delta"
+
+	alarmRising          = "rising"
+	alarmFalling         = "falling"
+	alarmRisingOrFalling = "rising-or-falling"
+)
+
+// threshold makes a trigger fire only when the value sampled from its path
+// crosses the rising or the falling threshold, like an RMON alarm.
+// After a rising alarm, another rising alarm is only sent once the value
+// crossed the falling threshold, and the other way around.
+type threshold struct {
+	// optional jq expression returning the sampled value,
+	// defaults to the trigger path value
+	Value string `yaml:"value,omitempty"`
+	// absolute (default), or delta: the difference with the previous value
+	SampleType string   `yaml:"sample-type,omitempty"`
+	Rising     *float64 `yaml:"rising,omitempty"`
+	Falling    *float64 `yaml:"falling,omitempty"`
+	// number of consecutive samples beyond a threshold
+	// needed to send an alarm, defaults to 1
+	Samples int `yaml:"samples,omitempty"`
+	// alarm allowed on the first sample of a path instance:
+	// rising, falling or rising-or-falling (default)
+	StartupAlarm string `yaml:"startup-alarm,omitempty"`
+
+	valueCode *gojq.Code
+}
+
+func (th *threshold) parseCode() error {
+	if th.Rising == nil || th.Falling == nil {
+		return fmt.Errorf("threshold missing \"rising\" or \"falling\"")
+	}
+	if *th.Rising < *th.Falling {
+		return fmt.Errorf("threshold rising %v is lower than falling %v", *th.Rising, *th.Falling)
+	}
+	if th.Samples < 0 {
+		return fmt.Errorf("negative threshold samples %d", th.Samples)
+	}
+	switch th.SampleType {
+	case "", sampleTypeAbsolute, sampleTypeDelta:
+	default:
+		return fmt.Errorf("unknown threshold sample-type %q", th.SampleType)
+	}
+	switch th.StartupAlarm {
+	case "", alarmRising, alarmFalling, alarmRisingOrFalling:
+	default:
+		return fmt.Errorf("unknown threshold startup-alarm %q", th.StartupAlarm)
+	}
+	var err error
+	if th.Value != "" {
+		th.valueCode, err = parseJQ(th.Value, builtinVars...)
+		if err != nil {
+			return fmt.Errorf("threshold value parse failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// startupAllows returns true if the alarm direction dir can be
+// sent for the first sample of a path instance.
+func (th *threshold) startupAllows(dir string) bool {
+	return th.StartupAlarm == "" || th.StartupAlarm == alarmRisingOrFalling || th.StartupAlarm == dir
+}
+
+// thresholdState is the alarm state of a trigger path instance.
+type thresholdState struct {
+	// last raw value, for delta samples
+	last    float64
+	sampled bool
+	// set once the first sample is evaluated
+	started bool
+	// last alarm direction, empty before the first alarm
+	alarm string
+	// direction of the consecutive samples beyond a threshold and their number
+	pending string
+	count   int
+	// the pending samples started with the first sample
+	startup bool
+}
+
+// record records the alarm direction dir as sent.
+func (st *thresholdState) record(dir string) {
+	st.alarm = dir
+	st.pending, st.count, st.startup = "", 0, false
+}
+
+// sample returns the value sampled from the event input.
+func (th *threshold) sample(tr *trigger, input map[string]any, builtins []any) (float64, error) {
+	var raw any
+	if th.valueCode != nil {
+		var err error
+		raw, err = runJQ(th.valueCode, input, builtins...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to evaluate threshold value: %v", err)
+		}
+	} else if values, ok := input["values"].(map[string]any); ok {
+		raw = values[tr.schemaPath]
+	}
+	v, err := toFloat(raw)
+	if err != nil {
+		return 0, fmt.Errorf("threshold value: %v", err)
+	}
+	return v, nil
+}
+
+// alarm updates the path instance state st with the sampled value v.
+// The returned alarm holds the sampled value and, if a threshold was crossed,
+// the alarm direction. The alarm direction is only recorded in st once
+// the alarm is sent, see thresholdState.record, so that an alarm
+// filtered out by the trigger condition is evaluated again on the next sample.
+func (th *threshold) alarm(st *thresholdState, v float64) map[string]any {
+	alarm := map[string]any{
+		"value":   jqNumber(v),
+		"rising":  jqNumber(*th.Rising),
+		"falling": jqNumber(*th.Falling),
+	}
+	if th.SampleType == sampleTypeDelta {
+		last, sampled := st.last, st.sampled
+		st.last, st.sampled = v, true
+		if !sampled {
+			// first value, no delta yet
+			return alarm
+		}
+		v -= last
+		alarm["value"] = jqNumber(v)
+	}
+	first := !st.started
+	st.started = true
+	var dir string
+	switch {
+	case v >= *th.Rising && st.alarm != alarmRising:
+		dir = alarmRising
+	case v <= *th.Falling && st.alarm != alarmFalling:
+		dir = alarmFalling
+	default:
+		st.pending, st.count, st.startup = "", 0, false
+		return alarm
+	}
+	if dir != st.pending {
+		st.pending, st.count, st.startup = dir, 0, first
+	}
+	st.count++
+	if st.count < th.Samples {
+		return alarm
+	}
+	if st.startup && !th.startupAllows(dir) {
+		// not sent, the value must cross the other threshold first
+		st.record(dir)
+		return alarm
+	}
+	alarm["direction"] = dir
+	return alarm
+}
+
+// jqNumber returns f as an int if it is integral, so that it can be
+// the value of an integer binding.
+func jqNumber(f float64) any {
+	if f == math.Trunc(f) && math.Abs(f) < math.MaxInt32 {
+		return int(f)
+	}
+	return f
+}
+
+func toFloat(v any) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	return 0, fmt.Errorf("unexpected type %T, wanted a number", v)
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestThresholdAlarm(t *testing.T) {
+	rising, falling := 85.0, 70.0
+	tests := []struct {
+		name         string
+		sampleType   string
+		samples      int
+		startupAlarm string
+		values       []float64
+		// the condition filters out the alarms at these indexes
+		filtered map[int]bool
+		// alarm direction for each value, empty for none
+		want []string
+	}{
+		{
+			name:   "rising_then_falling",
+			values: []float64{75, 90, 60},
+			want:   []string{"", "rising", "falling"},
+		},
+		{
+			name:   "startup_rising",
+			values: []float64{90, 60},
+			want:   []string{"rising", "falling"},
+		},
+		{
+			name:   "hysteresis",
+			values: []float64{90, 75, 95, 65, 72, 60, 90},
+			want:   []string{"rising", "", "", "falling", "", "", "rising"},
+		},
+		{
+			name:   "thresholds_included",
+			values: []float64{85, 70},
+			want:   []string{"rising", "falling"},
+		},
+		{
+			name:    "samples",
+			samples: 3,
+			values:  []float64{90, 91, 92, 93, 60, 75, 60, 60, 60},
+			want:    []string{"", "", "rising", "", "", "", "", "", "falling"},
+		},
+		{
+			name:    "samples_interrupted",
+			samples: 2,
+			values:  []float64{90, 80, 90, 90},
+			want:    []string{"", "", "", "rising"},
+		},
+		{
+			name:       "delta",
+			sampleType: sampleTypeDelta,
+			values:     []float64{100, 180, 270, 300, 380},
+			want:       []string{"", "", "rising", "falling", ""},
+		},
+		{
+			name:       "delta_first_value_not_sampled",
+			sampleType: sampleTypeDelta,
+			values:     []float64{1000, 1000},
+			want:       []string{"", "falling"},
+		},
+		{
+			name:         "startup_falling_suppressed",
+			startupAlarm: alarmRising,
+			values:       []float64{60, 90, 60},
+			want:         []string{"", "rising", "falling"},
+		},
+		{
+			name:         "startup_rising_suppressed",
+			startupAlarm: alarmFalling,
+			values:       []float64{90, 95, 60, 90},
+			want:         []string{"", "", "falling", "rising"},
+		},
+		{
+			name:         "startup_only_first_sample",
+			startupAlarm: alarmFalling,
+			values:       []float64{75, 90},
+			want:         []string{"", "rising"},
+		},
+		{
+			name:         "startup_samples",
+			startupAlarm: alarmFalling,
+			samples:      2,
+			values:       []float64{90, 90, 60, 60},
+			want:         []string{"", "", "", "falling"},
+		},
+		{
+			name:         "startup_delta",
+			sampleType:   sampleTypeDelta,
+			startupAlarm: alarmFalling,
+			values:       []float64{0, 90, 190},
+			want:         []string{"", "", ""},
+		},
+		{
+			name:     "filtered_alarm_not_recorded",
+			values:   []float64{90, 91, 60},
+			filtered: map[int]bool{0: true},
+			want:     []string{"rising", "rising", "falling"},
+		},
+		{
+			name:     "filtered_alarm_samples",
+			samples:  2,
+			values:   []float64{90, 91, 92, 93},
+			filtered: map[int]bool{1: true},
+			want:     []string{"", "rising", "rising", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := &threshold{
+				SampleType:   tt.sampleType,
+				Rising:       &rising,
+				Falling:      &falling,
+				Samples:      tt.samples,
+				StartupAlarm: tt.startupAlarm,
+			}
+			if err := th.parseCode(); err != nil {
+				t.Fatal(err)
+			}
+			st := new(thresholdState)
+			got := make([]string, 0, len(tt.values))
+			for i, v := range tt.values {
+				alarm := th.alarm(st, v)
+				dir, _ := alarm["direction"].(string)
+				got = append(got, dir)
+				if dir != "" && !tt.filtered[i] {
+					st.record(dir)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got alarms %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,39 +1,82 @@
 package app
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
+	g "github.com/gosnmp/gosnmp"
 	"github.com/itchyny/gojq"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
 
+var oidRegex = regexp.MustCompile(`^\.?[0-9]+(\.[0-9]+)+$`)
+
 type trapDefinition struct {
-	Name    string   `yaml:"name,omitempty"`
-	Trigger *trigger `yaml:"trigger,omitempty"`
+	Name string   `yaml:"name,omitempty"`
+	Tags []string `yaml:"tags,omitempty"`
+	// libraries the tasks and bindings "use" references are resolved from
+	Include []string `yaml:"include,omitempty"`
+	// a single trigger or a list of triggers
+	Trigger triggers `yaml:"trigger,omitempty"`
 	Tasks   []*task  `yaml:"tasks,omitempty"`
 	TrapPDU *trapPDU `yaml:"trap,omitempty"`
+	// test cases, run by the test command
+	Tests []*trapTest `yaml:"tests,omitempty"`
+
+	// file the definition was read from and its checksum
+	file     string
+	checksum [sha256.Size]byte
+	// libraries by name
+	libraries map[string]*library
+	// names of the variables published by the triggers,
+	// in order of first appearance
+	triggerVars []string
 }
 
 type trigger struct {
+	// gNMI path, with optional keys narrowing the subscription.
+	// a "*" in a key value matches any sequence of characters.
 	Path      string              `yaml:"path,omitempty"`
 	Condition string              `yaml:"condition,omitempty"`
 	Publish   []map[string]string `yaml:"publish,omitempty"`
+	// subscription mode and options
+	subscriptionMode `yaml:",inline"`
+	// update (default): fire on every update of the path,
+	// or change: fire only when the path instance value changes
+	On string `yaml:"on,omitempty"`
+	// optional threshold the path value must cross to fire
+	Threshold *threshold `yaml:"threshold,omitempty"`
+	// name of the subscription the trigger path is subscribed with
+	subscription string
+	// the state of the path instances is kept, see triggerState,
+	// and reset when the checksum of the trigger changes
+	stateful bool
+	checksum [sha256.Size]byte
 
+	// path without keys, as found in the events values
+	schemaPath string
+	// path keys values patterns by event tag name
+	keys          map[string]*regexp.Regexp
 	conditionCode *gojq.Code
 	publishCode   []map[string]*gojq.Code
 }
 
 type task struct {
+	// library task or variable set name
+	Use     string              `yaml:"use,omitempty"`
 	Name    string              `yaml:"name,omitempty"`
 	GNMI    *gNMITask           `yaml:"gnmi,omitempty"`
 	Publish []map[string]string `yaml:"publish,omitempty"`
 
 	publishCode []map[string]*gojq.Code
+	// library the task was copied from
+	library string
 }
 
 type gNMITask struct {
@@ -46,26 +89,48 @@ type gNMITask struct {
 
 type trapPDU struct {
 	InformPDU bool `yaml:"inform,omitempty"`
+	// notification OID, sent as snmpTrapOID.0 value.
+	// either a literal OID or a jq expression.
+	OID       string `yaml:"oid,omitempty"`
 	Community string
 	Bindings  []*binding
 
+	oidCode       *gojq.Code
 	communityCode *gojq.Code
 }
 
 type binding struct {
+	// library binding set name
+	Use   string `yaml:"use,omitempty"`
 	OID   string
 	Type  string
 	Value string
 
 	oidCode   *gojq.Code
 	valueCode *gojq.Code
+	// library the binding was copied from
+	library string
 }
 
-func (a *app) readTrapsDefinition() error {
-	return filepath.WalkDir(a.trapDir,
+// readTrapsDefinition reads and compiles the trap definition files under dir.
+// Each file is loaded on its own, the load error of each failed file
+// is returned in a map keyed by file path.
+// The returned error is only set if dir can't be walked.
+func readTrapsDefinition(dir string) ([]*trapDefinition, map[string]error, error) {
+	libs, fileErrs, err := readLibraries(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	traps := make([]*trapDefinition, 0)
+	names := make(map[string]string)
+	err = filepath.WalkDir(dir,
 		func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
-				return err
+				if path == dir {
+					return err
+				}
+				fileErrs[path] = err
+				return nil
 			}
 			if d.IsDir() {
 				return nil
@@ -74,60 +139,144 @@ func (a *app) readTrapsDefinition() error {
 			if ext != ".yaml" && ext != ".yml" {
 				return nil
 			}
-			b, err := os.ReadFile(path)
-			if err != nil {
-				return err
+			if _, ok := fileErrs[path]; ok {
+				// failed library file
+				return nil
 			}
-			t := new(trapDefinition)
-			err = yaml.Unmarshal(b, t)
+			t, err := readTrapFile(path, libs)
 			if err != nil {
-				return err
+				log.Errorf("failed to load trap definition file %q: %v", path, err)
+				fileErrs[path] = err
+				return nil
 			}
-			log.Infof("read trap: %+v", t)
-			if t.Name == "" {
-				t.Name = strings.TrimSuffix(path, ext)
+			if t == nil {
+				// library file
+				return nil
 			}
-			err = t.parseCode()
-			if err != nil {
-				return err
+			if f, ok := names[t.Name]; ok {
+				fileErrs[path] = fmt.Errorf("trap definition %q already defined in %q", t.Name, f)
+				log.Errorf("failed to load trap definition file %q: %v", path, fileErrs[path])
+				return nil
 			}
-			a.traps = append(a.traps, t)
+			names[t.Name] = path
+			traps = append(traps, t)
 			return nil
 		})
+	if err != nil {
+		return nil, nil, err
+	}
+	return traps, fileErrs, nil
+}
+
+// readTrapFile reads and compiles a single trap definition file,
+// resolving its includes from libs.
+// it returns nil and no error for a library or globals file.
+func readTrapFile(path string, libs map[string]*library) (*trapDefinition, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isLibrary(b) || isGlobals(b) {
+		return nil, nil
+	}
+	t := &trapDefinition{libraries: libs}
+	err = yaml.Unmarshal(b, t)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("read trap: %+v", t)
+	if t.Name == "" {
+		t.Name = strings.TrimSuffix(path, filepath.Ext(path))
+	}
+	t.file = path
+	t.checksum = sha256.Sum256(append(b, t.librariesChecksum()...))
+	err = t.parseCode()
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// source returns the file the trap definition was read from,
+// or "configuration" for a configured one.
+func (t *trapDefinition) source() string {
+	if t.file == "" {
+		return "configuration"
+	}
+	return t.file
+}
+
+// variables returns the names of the variables published
+// by the trigger and the tasks, in evaluation order.
+func (t *trapDefinition) variables() []string {
+	vars := make([]string, 0, len(t.triggerVars))
+	vars = append(vars, t.triggerVars...)
+	for _, tsk := range t.Tasks {
+		for _, mc := range tsk.publishCode {
+			for k := range mc {
+				vars = append(vars, k)
+			}
+		}
+	}
+	return vars
 }
 
 func (t *trapDefinition) parseCode() error {
-	if t.Trigger == nil {
+	err := t.resolveLibraries()
+	if err != nil {
+		return err
+	}
+	if len(t.Trigger) == 0 {
 		return fmt.Errorf("trap definition %q missing \"trigger\"", t.Name)
 	}
-	if t.Trigger.Path == "" {
-		return fmt.Errorf("trap definition %q missing \"trigger.path\"", t.Name)
+	for idx, tr := range t.Trigger {
+		if tr == nil || tr.Path == "" {
+			return fmt.Errorf("trap definition %q trigger index %d missing \"path\"", t.Name, idx)
+		}
 	}
 	if t.TrapPDU == nil {
 		return fmt.Errorf("trap definition %q missing trap PDU definition under \"trap\"", t.Name)
 	}
+	if t.TrapPDU.OID == "" {
+		return fmt.Errorf("trap definition %q missing notification OID under \"trap.oid\"", t.Name)
+	}
 	if len(t.TrapPDU.Bindings) == 0 {
 		return fmt.Errorf("trap definition %q missing trap PDU bindings under \"trap.bindings\"", t.Name)
 	}
 
-	var err error
-	err = t.Trigger.parseCode()
-	if err != nil {
-		return fmt.Errorf("trap definition %q trigger parse failed: %v", t.Name, err)
+	t.triggerVars = make([]string, 0)
+	published := make(map[string]struct{})
+	for idx, tr := range t.Trigger {
+		err = tr.parseCode()
+		if err != nil {
+			return fmt.Errorf("trap definition %q trigger index %d parse failed: %w", t.Name, idx, err)
+		}
+		for _, mc := range tr.publishCode {
+			for k := range mc {
+				if _, ok := published[k]; ok {
+					continue
+				}
+				published[k] = struct{}{}
+				t.triggerVars = append(t.triggerVars, k)
+			}
+		}
 	}
 
-	triggerVars := make([]string, 0, len(t.Trigger.Publish)+len(t.Tasks))
-	for _, mk := range t.Trigger.Publish {
-		for k := range mk {
-			triggerVars = append(triggerVars, "$"+k)
-		}
+	// the builtin variables are followed by the variables published by the triggers
+	triggerVars := make([]string, 0, len(builtinVars)+len(t.triggerVars)+len(t.Tasks))
+	triggerVars = append(triggerVars, builtinVars...)
+	for _, k := range t.triggerVars {
+		triggerVars = append(triggerVars, "$"+k)
 	}
 
 	log.Debugf("trap definition %q: triggerVars: %v", t.Name, triggerVars)
 	for idx, tsk := range t.Tasks {
 		err = tsk.parseCode(triggerVars...)
 		if err != nil {
-			return fmt.Errorf("trap definition %q task index %d parse failed: %v", t.Name, idx, err)
+			if tsk.library != "" {
+				return fmt.Errorf("trap definition %q task index %d (%q from library %q) parse failed: %w", t.Name, idx, tsk.Name, tsk.library, err)
+			}
+			return fmt.Errorf("trap definition %q task index %d parse failed: %w", t.Name, idx, err)
 		}
 		for _, mk := range tsk.Publish {
 			for k := range mk {
@@ -137,34 +286,121 @@ func (t *trapDefinition) parseCode() error {
 	}
 
 	log.Debugf("trap definition %q: allVars: %v", t.Name, triggerVars)
+	if !isOID(t.TrapPDU.OID) {
+		t.TrapPDU.oidCode, err = parseJQ(t.TrapPDU.OID, triggerVars...)
+		if err != nil {
+			return fmt.Errorf("trap definition %q oid parse failed: %w", t.Name, err)
+		}
+	}
 	if t.TrapPDU.Community != "" {
 		t.TrapPDU.communityCode, err = parseJQ(t.TrapPDU.Community, triggerVars...)
 		if err != nil {
-			return fmt.Errorf("trap definition %q community parse failed: %v", t.Name, err)
+			return fmt.Errorf("trap definition %q community parse failed: %w", t.Name, err)
 		}
 	}
 
 	for idx, binding := range t.TrapPDU.Bindings {
 		err = binding.parseCode(triggerVars...)
 		if err != nil {
-			return fmt.Errorf("trap definition %q binding index %d parse failed: %v", t.Name, idx, err)
+			if binding.library != "" {
+				return fmt.Errorf("trap definition %q binding index %d (from library %q) parse failed: %w", t.Name, idx, binding.library, err)
+			}
+			return fmt.Errorf("trap definition %q binding index %d parse failed: %w", t.Name, idx, err)
 		}
 	}
+	// the previous values are only kept if they are used
+	usesPrevious := t.usesVar(previousVar)
+	for _, tr := range t.Trigger {
+		tr.stateful = usesPrevious || tr.On == triggerOnChange || tr.Threshold != nil
+	}
 	return nil
 }
 
+// usesVar returns true if one of the trap definition expressions
+// references the variable name.
+func (t *trapDefinition) usesVar(name string) bool {
+	re := regexp.MustCompile(regexp.QuoteMeta(name) + `\b`)
+	exprs := []string{t.TrapPDU.OID, t.TrapPDU.Community}
+	for _, tr := range t.Trigger {
+		exprs = append(exprs, tr.Condition)
+		for _, mkv := range tr.Publish {
+			for _, v := range mkv {
+				exprs = append(exprs, v)
+			}
+		}
+		if tr.Threshold != nil {
+			exprs = append(exprs, tr.Threshold.Value)
+		}
+	}
+	for _, tsk := range t.Tasks {
+		if tsk.GNMI != nil {
+			exprs = append(exprs, tsk.GNMI.Path)
+		}
+		for _, mkv := range tsk.Publish {
+			for _, v := range mkv {
+				exprs = append(exprs, v)
+			}
+		}
+	}
+	for _, b := range t.TrapPDU.Bindings {
+		exprs = append(exprs, b.OID, b.Value)
+	}
+	for _, e := range exprs {
+		if re.MatchString(e) {
+			return true
+		}
+	}
+	return false
+}
+
 func (tr *trigger) parseCode() error {
-	var err error
+	err := tr.parsePath()
+	if err != nil {
+		return err
+	}
+	err = tr.subscriptionMode.validate()
+	if err != nil {
+		return err
+	}
+	tr.subscription = subscriptionName(&tr.subscriptionMode, tr.Path)
+	switch tr.On {
+	case "", triggerOnUpdate, triggerOnChange:
+	default:
+		return &valueError{
+			key:   "on",
+			value: tr.On,
+			err:   fmt.Errorf("unknown \"on\" value %q, expected %q or %q", tr.On, triggerOnUpdate, triggerOnChange),
+		}
+	}
+	if tr.Threshold != nil {
+		// the threshold samples the path value at a regular interval
+		if tr.Mode != subscriptionModeSample {
+			key := "mode"
+			if tr.Mode == "" {
+				key = "threshold"
+			}
+			return &valueError{
+				key:   key,
+				value: tr.Mode,
+				err:   fmt.Errorf("threshold is only valid with mode %q", subscriptionModeSample),
+			}
+		}
+		err = tr.Threshold.parseCode()
+		if err != nil {
+			return err
+		}
+	}
 	if tr.Condition != "" {
-		tr.conditionCode, err = parseJQ(tr.Condition)
+		tr.conditionCode, err = parseJQ(tr.Condition, builtinVars...)
 		if err != nil {
 			return err
 		}
 	}
+	tr.checksum = tr.stateChecksum()
 	tr.publishCode = make([]map[string]*gojq.Code, 0, len(tr.Publish))
 	for _, mkv := range tr.Publish {
 		for k, v := range mkv {
-			c, err := parseJQ(v)
+			c, err := parseJQ(v, builtinVars...)
 			if err != nil {
 				return err
 			}
@@ -177,6 +413,13 @@ func (tr *trigger) parseCode() error {
 func (tsk *task) parseCode(prevTasks ...string) error {
 	var err error
 	if tsk.GNMI != nil {
+		if tsk.GNMI.RPC != "" && tsk.GNMI.RPC != "get" {
+			return &valueError{
+				key:   "rpc",
+				value: tsk.GNMI.RPC,
+				err:   fmt.Errorf("unsupported gnmi rpc %q", tsk.GNMI.RPC),
+			}
+		}
 		tsk.GNMI.pathCode, err = parseJQ(tsk.GNMI.Path, prevTasks...)
 		if err != nil {
 			return err
@@ -196,6 +439,13 @@ func (tsk *task) parseCode(prevTasks ...string) error {
 }
 
 func (b *binding) parseCode(prevTasks ...string) error {
+	if pduType(b.Type) == g.UnknownType {
+		return &valueError{
+			key:   "type",
+			value: b.Type,
+			err:   fmt.Errorf("unknown type %q", b.Type),
+		}
+	}
 	var err error
 	b.oidCode, err = parseJQ(b.OID, prevTasks...)
 	if err != nil {
@@ -205,10 +455,38 @@ func (b *binding) parseCode(prevTasks ...string) error {
 	return err
 }
 
+// parseJQ compiles the jq expression code with the variables prevVars,
+// an undefined variable is a compilation error.
 func parseJQ(code string, prevVars ...string) (*gojq.Code, error) {
-	q, err := gojq.Parse(strings.TrimSpace(code))
+	code = strings.TrimSpace(code)
+	q, err := gojq.Parse(code)
 	if err != nil {
-		return nil, err
+		return nil, &valueError{value: code, err: err}
+	}
+	c, err := gojq.Compile(q, gojq.WithVariables(prevVars))
+	if err != nil {
+		return nil, &valueError{value: code, err: err}
 	}
-	return gojq.Compile(q, gojq.WithVariables(prevVars))
+	return c, nil
+}
+
+// valueError is an error caused by a value of a trap definition,
+// the validate command reports it at the line of the value.
+type valueError struct {
+	// key the value is set with, if it is not a jq expression
+	key   string
+	value string
+	err   error
+}
+
+func (e *valueError) Error() string {
+	return e.err.Error()
+}
+
+func (e *valueError) Unwrap() error {
+	return e.err
+}
+
+func isOID(s string) bool {
+	return oidRegex.MatchString(strings.TrimSpace(s))
 }
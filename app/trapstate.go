@@ -0,0 +1,76 @@
+package app
+
+import (
+	"fmt"
+)
+
+// trapDefinitionState is the state of a loaded trap definition,
+// published under .system.snmp-traps.trap-definition.
+type trapDefinitionState struct {
+	// trap definition file, or "configuration"
+	Source       string   `json:"source,omitempty"`
+	TriggerPaths []string `json:"trigger-path,omitempty"`
+	// variables published by the trigger and the tasks
+	Variables []string `json:"variables,omitempty"`
+	Status    string   `json:"status,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	// the trap definition is enabled and subscribed to
+	Active bool `json:"active"`
+}
+
+// updateTrapDefinitionsTelemetry publishes the state of the loaded trap definitions,
+// and deletes the state of the definitions not loaded anymore.
+func (a *app) updateTrapDefinitionsTelemetry() {
+	a.trapsM.Lock()
+	active := make(map[string]struct{}, len(a.traps))
+	for _, t := range a.traps {
+		active[t.Name] = struct{}{}
+	}
+	states := make(map[string]*trapDefinitionState)
+	for _, t := range a.loadedTraps() {
+		st := &trapDefinitionState{
+			Source:       t.source(),
+			TriggerPaths: t.Trigger.paths(),
+			Variables:    t.variables(),
+			Status:       "loaded",
+		}
+		_, st.Active = active[t.Name]
+		// previous version of a file failing to load
+		if fst, ok := a.trapFiles[t.file]; ok && fst.Status == "failed" {
+			st.Status = "failed"
+			st.Error = fst.Error
+		}
+		states[t.Name] = st
+	}
+	for name, err := range a.cfgTrapErrs {
+		st, ok := states[name]
+		if !ok {
+			st = &trapDefinitionState{Source: "configuration"}
+			states[name] = st
+		}
+		st.Status = "failed"
+		st.Error = err.Error()
+	}
+	deleted := make([]string, 0)
+	for name := range a.trapDefsTelem {
+		if _, ok := states[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	a.trapDefsTelem = make(map[string]struct{}, len(states))
+	for name := range states {
+		a.trapDefsTelem[name] = struct{}{}
+	}
+	a.trapsM.Unlock()
+
+	for name, st := range states {
+		updateTelemetryCh(a.tuCh, trapDefinitionTelemPath(name), st)
+	}
+	for _, name := range deleted {
+		deleteTelemetryCh(a.tuCh, trapDefinitionTelemPath(name))
+	}
+}
+
+func trapDefinitionTelemPath(name string) string {
+	return fmt.Sprintf("%s{.name==\"%s\"}", snmpTrapsTrapDefinitionPath, name)
+}
@@ -0,0 +1,456 @@
+package app
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmic/api"
+	"github.com/openconfig/gnmic/formatters"
+	"github.com/openconfig/gnmic/utils"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// keysVar is the jq variable holding the trigger path keys
+	// matched by the event, by event tag name with its hyphens
+	// replaced by underscores, see keyName.
+	keysVar = "$keys"
+	// triggerVar is the jq variable holding the path of the trigger
+	// that fired, as written in the trap definition.
+	triggerVar = "$trigger"
+	// previousVar and currentVar are the jq variables holding the previous
+	// and the current value of the trigger path instance that fired.
+	previousVar = "$previous"
+	currentVar  = "$current"
+)
+
+// builtinVars are the first variables of every expression,
+// followed by the variables published by the triggers and the tasks.
+var builtinVars = []string{globalsVar, keysVar, triggerVar, thresholdVar, previousVar, currentVar}
+
+const (
+	// the trigger fires on every update of its path
+	triggerOnUpdate = "update"
+	// the trigger fires only when the value of its path instance changes
+	triggerOnChange = "change"
+)
+
+const (
+	subscriptionModeOnChange      = "on-change"
+	subscriptionModeSample        = "sample"
+	subscriptionModeTargetDefined = "target-defined"
+)
+
+// subscriptionMode is the mode and options of a trigger path subscription.
+// The triggers with the same path, mode and options share a subscription.
+type subscriptionMode struct {
+	// on-change (default), sample or target-defined
+	Mode           string   `yaml:"mode,omitempty"`
+	SampleInterval interval `yaml:"sample-interval,omitempty"`
+	// on-change and sample subscriptions heartbeat interval
+	HeartbeatInterval interval `yaml:"heartbeat-interval,omitempty"`
+	// sample subscriptions only send the values that changed
+	SuppressRedundant bool `yaml:"suppress-redundant,omitempty"`
+}
+
+func (m *subscriptionMode) validate() error {
+	switch m.Mode {
+	case "", subscriptionModeOnChange, subscriptionModeSample, subscriptionModeTargetDefined:
+	default:
+		return &valueError{
+			key:   "mode",
+			value: m.Mode,
+			err:   fmt.Errorf("unknown subscription mode %q", m.Mode),
+		}
+	}
+	if m.SampleInterval < 0 || m.HeartbeatInterval < 0 {
+		return fmt.Errorf("negative sample or heartbeat interval")
+	}
+	// the gNMI server intervals have a one second granularity
+	if (m.SampleInterval > 0 && m.SampleInterval < interval(time.Second)) ||
+		(m.HeartbeatInterval > 0 && m.HeartbeatInterval < interval(time.Second)) {
+		return fmt.Errorf("sample and heartbeat intervals must be at least 1s")
+	}
+	if m.Mode != subscriptionModeSample {
+		if m.SampleInterval != 0 {
+			return fmt.Errorf("\"sample-interval\" is only valid with mode %q", subscriptionModeSample)
+		}
+		if m.SuppressRedundant {
+			return fmt.Errorf("\"suppress-redundant\" is only valid with mode %q", subscriptionModeSample)
+		}
+	}
+	if m.Mode == subscriptionModeTargetDefined && m.HeartbeatInterval != 0 {
+		return fmt.Errorf("\"heartbeat-interval\" is not valid with mode %q", subscriptionModeTargetDefined)
+	}
+	return nil
+}
+
+// name returns the mode and its options, as used in the subscriptions names.
+func (m *subscriptionMode) name() string {
+	sb := new(strings.Builder)
+	switch m.Mode {
+	case "":
+		sb.WriteString(subscriptionModeOnChange)
+	default:
+		sb.WriteString(m.Mode)
+	}
+	if m.SampleInterval > 0 {
+		fmt.Fprintf(sb, ",sample-interval=%s", time.Duration(m.SampleInterval))
+	}
+	if m.HeartbeatInterval > 0 {
+		fmt.Fprintf(sb, ",heartbeat-interval=%s", time.Duration(m.HeartbeatInterval))
+	}
+	if m.SuppressRedundant {
+		sb.WriteString(",suppress-redundant")
+	}
+	return sb.String()
+}
+
+// options returns the gNMI subscription options of the mode.
+func (m *subscriptionMode) options() []api.GNMIOption {
+	opts := make([]api.GNMIOption, 0, 4)
+	switch m.Mode {
+	case subscriptionModeSample:
+		opts = append(opts, api.SubscriptionModeSAMPLE())
+	case subscriptionModeTargetDefined:
+		opts = append(opts, api.SubscriptionModeTARGET_DEFINED())
+	default:
+		opts = append(opts, api.SubscriptionModeON_CHANGE())
+	}
+	if m.SampleInterval > 0 {
+		opts = append(opts, api.SampleInterval(time.Duration(m.SampleInterval)))
+	}
+	if m.HeartbeatInterval > 0 {
+		opts = append(opts, api.HeartbeatInterval(time.Duration(m.HeartbeatInterval)))
+	}
+	if m.SuppressRedundant {
+		opts = append(opts, api.SuppressRedundant(true))
+	}
+	return opts
+}
+
+// interval is a subscription interval, written in YAML as a duration
+// with a unit, e.g. 10s, or as a number of seconds like in the configuration.
+type interval time.Duration
+
+func (i *interval) UnmarshalYAML(unmarshal func(any) error) error {
+	var secs int64
+	if err := unmarshal(&secs); err == nil {
+		*i = interval(time.Duration(secs) * time.Second)
+		return nil
+	}
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*i = interval(d)
+	return nil
+}
+
+// triggers is the trap definition triggers list,
+// written in YAML as a single trigger or a list of triggers.
+type triggers []*trigger
+
+func (trs *triggers) UnmarshalYAML(unmarshal func(any) error) error {
+	var raw any
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	if _, ok := raw.([]any); ok {
+		l := make([]*trigger, 0)
+		if err := unmarshal(&l); err != nil {
+			return err
+		}
+		*trs = l
+		return nil
+	}
+	tr := new(trigger)
+	if err := unmarshal(tr); err != nil {
+		return err
+	}
+	*trs = triggers{tr}
+	return nil
+}
+
+// paths returns the triggers paths.
+func (trs triggers) paths() []string {
+	ps := make([]string, 0, len(trs))
+	for _, tr := range trs {
+		ps = append(ps, tr.Path)
+	}
+	return ps
+}
+
+// parsePath parses the trigger path into the schema path matched against
+// the events values and the keys matched against the events tags.
+// The keys are kept in the subscription path, a "*" in a key value
+// matches any sequence of characters.
+func (tr *trigger) parsePath() error {
+	gp, err := utils.ParsePath(tr.Path)
+	if err != nil {
+		return err
+	}
+	tr.schemaPath = "/" + utils.GnmiPathToXPath(gp, true)
+	tr.keys = make(map[string]*regexp.Regexp)
+	for _, e := range gp.GetElem() {
+		// same tag names as the gNMI notifications converted to events
+		elems := strings.Split(e.GetName(), ":")
+		for k, v := range e.GetKey() {
+			tr.keys[elems[len(elems)-1]+"_"+k] = keyRegex(v)
+		}
+	}
+	return nil
+}
+
+func keyRegex(v string) *regexp.Regexp {
+	parts := strings.Split(v, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// match returns the trigger path keys values found in the event tags,
+// by key name, and true if the event matches the trigger path.
+func (tr *trigger) match(ev *formatters.EventMsg) (map[string]any, bool) {
+	if _, ok := ev.Values[tr.schemaPath]; !ok {
+		return nil, false
+	}
+	keys := make(map[string]any, len(tr.keys))
+	for name, re := range tr.keys {
+		v, ok := ev.Tags[name]
+		if !ok || !re.MatchString(v) {
+			return nil, false
+		}
+		keys[keyName(name)] = v
+	}
+	return keys, true
+}
+
+// stateChecksum returns the checksum of the trigger attributes
+// the state of its path instances depends on.
+func (tr *trigger) stateChecksum() [sha256.Size]byte {
+	b, _ := yaml.Marshal(&trigger{
+		Path:             tr.Path,
+		Condition:        tr.Condition,
+		subscriptionMode: tr.subscriptionMode,
+		On:               tr.On,
+		Threshold:        tr.Threshold,
+	})
+	return sha256.Sum256(b)
+}
+
+// keyName returns the name of the event tag name in $keys.
+// The hyphens are replaced by underscores so that the keys of
+// hyphenated path elements are valid jq identifiers,
+// e.g. $keys.network_instance_name for the tag network-instance_name.
+func keyName(tag string) string {
+	return strings.ReplaceAll(tag, "-", "_")
+}
+
+// triggerVarsValues returns the values of the variables published by
+// all the triggers of the definition, from the values rs published by tr.
+// The variables tr does not publish are null.
+func (t *trapDefinition) triggerVarsValues(tr *trigger, rs []any) []any {
+	vals := make(map[string]any, len(rs))
+	for _, v := range publishedVars(tr.publishCode, rs) {
+		vals[v.name] = v.value
+	}
+	vs := make([]any, 0, len(t.triggerVars))
+	for _, name := range t.triggerVars {
+		vs = append(vs, vals[name])
+	}
+	return vs
+}
+
+// triggerState is the state of a trigger path instance,
+// only kept for the stateful triggers.
+type triggerState struct {
+	trap string
+	// trigger index and path instance tags
+	idx  int
+	tags map[string]string
+	// checksum of the trigger the state was built with, see trigger.stateChecksum
+	checksum [sha256.Size]byte
+	// last value of the path instance
+	previous any
+	seen     bool
+	// threshold triggers alarm state
+	threshold thresholdState
+}
+
+// triggerStates holds the state of each trigger path instance.
+type triggerStates struct {
+	m      *sync.Mutex
+	states map[string]*triggerState
+}
+
+func newTriggerStates() *triggerStates {
+	return &triggerStates{
+		m:      new(sync.Mutex),
+		states: make(map[string]*triggerState),
+	}
+}
+
+// get returns the state of the path instance identified by tags
+// of the trigger index idx of the trap definition t.
+// The state is reset if the trigger changed, it is kept when only
+// the other parts of the definition, such as its tasks or bindings, changed.
+// must be called with the lock held.
+func (ts *triggerStates) get(t *trapDefinition, idx int, tags map[string]string) *triggerState {
+	key := fmt.Sprintf("%s\x00%d\x00%s", t.Name, idx, instanceKey(tags))
+	st, ok := ts.states[key]
+	checksum := t.Trigger[idx].checksum
+	if !ok || st.checksum != checksum {
+		st = &triggerState{trap: t.Name, idx: idx, tags: tags, checksum: checksum}
+		ts.states[key] = st
+	}
+	return st
+}
+
+// delete deletes the states of the path instances of the trigger index idx
+// of the trap definition t that have all the tags.
+// must be called with the lock held.
+func (ts *triggerStates) delete(t *trapDefinition, idx int, tags map[string]string) {
+	for k, st := range ts.states {
+		if st.trap != t.Name || st.idx != idx || !hasTags(st.tags, tags) {
+			continue
+		}
+		delete(ts.states, k)
+	}
+}
+
+// hasTags returns true if all the tags are found in m.
+func hasTags(m, tags map[string]string) bool {
+	for k, v := range tags {
+		if mv, ok := m[k]; !ok || mv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// prune deletes the states of the trap definitions not in traps.
+func (ts *triggerStates) prune(traps []*trapDefinition) {
+	names := make(map[string]struct{}, len(traps))
+	for _, t := range traps {
+		names[t.Name] = struct{}{}
+	}
+	ts.m.Lock()
+	defer ts.m.Unlock()
+	for k, st := range ts.states {
+		if _, ok := names[st.trap]; !ok {
+			delete(ts.states, k)
+		}
+	}
+}
+
+// instanceKey returns the event tags as a string identifying
+// the path instance of the event.
+func instanceKey(tags map[string]string) string {
+	ks := make([]string, 0, len(tags))
+	for k, v := range tags {
+		ks = append(ks, k+"="+v)
+	}
+	sort.Strings(ks)
+	return strings.Join(ks, ",")
+}
+
+// updateTriggerState records the value of the trigger index idx path instance
+// and evaluates its threshold, if any.
+// It returns the previous value of the path instance, nil if there is none,
+// the threshold alarm, and false if the trigger must not fire: its value did
+// not change with `on: change`, or no threshold was crossed.
+func (a *app) updateTriggerState(t *trapDefinition, idx int, tags map[string]string, input map[string]any, builtins []any) (any, map[string]any, bool, error) {
+	tr := t.Trigger[idx]
+	if !tr.stateful {
+		return nil, nil, true, nil
+	}
+	var sample float64
+	if tr.Threshold != nil {
+		var err error
+		sample, err = tr.Threshold.sample(tr, input, builtins)
+		if err != nil {
+			return nil, nil, false, err
+		}
+	}
+	var current any
+	if values, ok := input["values"].(map[string]any); ok {
+		current = values[tr.schemaPath]
+	}
+
+	a.triggerStates.m.Lock()
+	defer a.triggerStates.m.Unlock()
+	st := a.triggerStates.get(t, idx, tags)
+	previous, seen := st.previous, st.seen
+	st.previous, st.seen = current, true
+	if tr.On == triggerOnChange && (!seen || reflect.DeepEqual(previous, current)) {
+		return previous, nil, false, nil
+	}
+	if tr.Threshold == nil {
+		return previous, nil, true, nil
+	}
+	alarm := tr.Threshold.alarm(&st.threshold, sample)
+	_, fire := alarm["direction"]
+	return previous, alarm, fire, nil
+}
+
+// recordAlarm records the threshold alarm of the trigger index idx
+// path instance as sent, see thresholdState.record.
+func (a *app) recordAlarm(t *trapDefinition, idx int, tags map[string]string, alarm map[string]any) {
+	dir, ok := alarm["direction"].(string)
+	if !ok {
+		return
+	}
+	a.triggerStates.m.Lock()
+	defer a.triggerStates.m.Unlock()
+	a.triggerStates.get(t, idx, tags).threshold.record(dir)
+}
+
+// deleteTriggerStates deletes the states of the trap definition t triggers
+// path instances under the paths deleted by the event ev.
+// The triggers subscribed with another subscription than sub are skipped.
+func (a *app) deleteTriggerStates(t *trapDefinition, sub string, ev *formatters.EventMsg) {
+	a.triggerStates.m.Lock()
+	defer a.triggerStates.m.Unlock()
+	for _, del := range ev.Deletes {
+		gp, err := utils.ParsePath(del)
+		if err != nil {
+			log.Errorf("failed to parse deleted path %q: %v", del, err)
+			continue
+		}
+		// the deleted path keys are named like the events tags
+		tags := make(map[string]string, len(ev.Tags))
+		for k, v := range ev.Tags {
+			tags[k] = v
+		}
+		for _, e := range gp.GetElem() {
+			elems := strings.Split(e.GetName(), ":")
+			for k, v := range e.GetKey() {
+				tags[elems[len(elems)-1]+"_"+k] = v
+			}
+		}
+		// the deleted path may be relative to the notification prefix
+		schemaPath := "/" + utils.GnmiPathToXPath(gp, true) + "/"
+		for idx, tr := range t.Trigger {
+			if !tr.stateful || (sub != "" && sub != tr.subscription) {
+				continue
+			}
+			if !strings.Contains(tr.schemaPath+"/", schemaPath) {
+				continue
+			}
+			a.triggerStates.delete(t, idx, tags)
+		}
+	}
+}
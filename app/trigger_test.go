@@ -0,0 +1,279 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmic/formatters"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSubscriptionModeIntervals(t *testing.T) {
+	tests := []struct {
+		name          string
+		yaml          string
+		wantSample    time.Duration
+		wantHeartbeat time.Duration
+		wantErr       bool
+	}{
+		{
+			name:       "duration",
+			yaml:       "mode: sample\nsample-interval: 10s\n",
+			wantSample: 10 * time.Second,
+		},
+		{
+			name:       "seconds",
+			yaml:       "mode: sample\nsample-interval: 10\n",
+			wantSample: 10 * time.Second,
+		},
+		{
+			name:          "heartbeat_minutes",
+			yaml:          "heartbeat-interval: 5m\n",
+			wantHeartbeat: 5 * time.Minute,
+		},
+		{
+			name:    "sub_second",
+			yaml:    "mode: sample\nsample-interval: 500ms\n",
+			wantErr: true,
+		},
+		{
+			name:    "sub_second_heartbeat",
+			yaml:    "heartbeat-interval: 1ns\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid_duration",
+			yaml:    "mode: sample\nsample-interval: ten\n",
+			wantErr: true,
+		},
+		{
+			name:    "negative",
+			yaml:    "mode: sample\nsample-interval: -10\n",
+			wantErr: true,
+		},
+		{
+			name:    "sample_interval_on_change",
+			yaml:    "sample-interval: 10s\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := new(subscriptionMode)
+			err := yaml.Unmarshal([]byte(tt.yaml), m)
+			if err == nil {
+				err = m.validate()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if time.Duration(m.SampleInterval) != tt.wantSample || time.Duration(m.HeartbeatInterval) != tt.wantHeartbeat {
+				t.Errorf("got sample-interval %s heartbeat-interval %s, want %s and %s",
+					time.Duration(m.SampleInterval), time.Duration(m.HeartbeatInterval), tt.wantSample, tt.wantHeartbeat)
+			}
+		})
+	}
+}
+
+func TestTriggerStates(t *testing.T) {
+	const bindings = `trap:
+  oid: 1.3.6.1.6.3.1.1.5.3
+  bindings:
+    - oid: '".1.3.6.1.2.1.2.2.1.8.1"'
+      type: int
+      value: 2
+`
+	operState := func(name, v string) *formatters.EventMsg {
+		return &formatters.EventMsg{
+			Tags:   map[string]string{"interface_name": name},
+			Values: map[string]any{"/interface/oper-state": v},
+		}
+	}
+	deleted := func(p string) *formatters.EventMsg {
+		return &formatters.EventMsg{Tags: map[string]string{}, Deletes: []string{p}}
+	}
+	tests := []struct {
+		name       string
+		definition string
+		events     []*formatters.EventMsg
+		// definition reloaded before the event index reloadAt
+		reload   string
+		reloadAt int
+		// trap sent for each event
+		want       []bool
+		wantStates int
+	}{
+		{
+			name: "stateless",
+			definition: `name: test
+trigger:
+  path: /interface[name=*]/oper-state
+` + bindings,
+			events:     []*formatters.EventMsg{operState("ethernet-1/1", "down"), operState("ethernet-1/1", "down")},
+			want:       []bool{true, true},
+			wantStates: 0,
+		},
+		{
+			name: "previous_used",
+			definition: `name: test
+trigger:
+  path: /interface[name=*]/oper-state
+  condition: $previous != null
+` + bindings,
+			events:     []*formatters.EventMsg{operState("ethernet-1/1", "up"), operState("ethernet-1/1", "down")},
+			want:       []bool{false, true},
+			wantStates: 1,
+		},
+		{
+			name: "on_change",
+			definition: `name: test
+trigger:
+  path: /interface[name=*]/oper-state
+  on: change
+` + bindings,
+			events: []*formatters.EventMsg{
+				operState("ethernet-1/1", "up"),
+				operState("ethernet-1/1", "up"),
+				operState("ethernet-1/1", "down"),
+				operState("ethernet-1/2", "down"),
+			},
+			want:       []bool{false, false, true, false},
+			wantStates: 2,
+		},
+		{
+			name: "following_trigger_state_updated",
+			definition: `name: test
+trigger:
+  - path: /interface[name=*]/oper-state
+    condition: $current == "down"
+  - path: /interface[name=*]/oper-state
+    on: change
+` + bindings,
+			events:     []*formatters.EventMsg{operState("ethernet-1/1", "down"), operState("ethernet-1/1", "up")},
+			want:       []bool{true, true},
+			wantStates: 1,
+		},
+		{
+			name: "deleted_interface",
+			definition: `name: test
+trigger:
+  path: /interface[name=*]/oper-state
+  on: change
+` + bindings,
+			events: []*formatters.EventMsg{
+				operState("ethernet-1/1", "up"),
+				operState("ethernet-1/2", "up"),
+				deleted("/interface[name=ethernet-1/1]"),
+				operState("ethernet-1/1", "down"),
+			},
+			want:       []bool{false, false, false, false},
+			wantStates: 2,
+		},
+		{
+			name: "deleted_leaf",
+			definition: `name: test
+trigger:
+  path: /interface[name=*]/oper-state
+  on: change
+` + bindings,
+			events: []*formatters.EventMsg{
+				operState("ethernet-1/1", "up"),
+				deleted("/interface[name=ethernet-1/1]/oper-state"),
+				operState("ethernet-1/1", "down"),
+			},
+			want:       []bool{false, false, false},
+			wantStates: 1,
+		},
+		{
+			name: "deleted_other_path",
+			definition: `name: test
+trigger:
+  path: /interface[name=*]/oper-state
+  on: change
+` + bindings,
+			events: []*formatters.EventMsg{
+				operState("ethernet-1/1", "up"),
+				deleted("/interface[name=ethernet-1/1]/description"),
+				operState("ethernet-1/1", "down"),
+			},
+			want:       []bool{false, false, true},
+			wantStates: 1,
+		},
+		{
+			name: "bindings_reloaded",
+			definition: `name: test
+trigger:
+  path: /interface[name=*]/oper-state
+  on: change
+` + bindings,
+			events:   []*formatters.EventMsg{operState("ethernet-1/1", "up"), operState("ethernet-1/1", "down")},
+			reload:   strings.Replace(bindings, "value: 2", "value: 1", 1),
+			reloadAt: 1,
+			want:     []bool{false, true},
+			// the state is rebuilt on the first event after the reload
+			wantStates: 1,
+		},
+		{
+			name: "trigger_reloaded",
+			definition: `name: test
+trigger:
+  path: /interface[name=*]/oper-state
+  on: change
+` + bindings,
+			events:     []*formatters.EventMsg{operState("ethernet-1/1", "up"), operState("ethernet-1/1", "down")},
+			reload:     `  condition: $current == "down"` + "\n" + bindings,
+			reloadAt:   1,
+			want:       []bool{false, false},
+			wantStates: 1,
+		},
+	}
+	parse := func(t *testing.T, definition string) *trapDefinition {
+		td := new(trapDefinition)
+		if err := yaml.Unmarshal([]byte(definition), td); err != nil {
+			t.Fatal(err)
+		}
+		td.checksum = sha256.Sum256([]byte(definition))
+		if err := td.parseCode(); err != nil {
+			t.Fatal(err)
+		}
+		return td
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := parse(t, tt.definition)
+			var sent bool
+			a := newOfflineApp([]*trapDefinition{td}, fixtureGetter{},
+				func(_ *trapDefinition, _ string, v any) {
+					if _, ok := v.(*notification); ok {
+						sent = true
+					}
+				})
+			got := make([]bool, 0, len(tt.events))
+			for i, ev := range tt.events {
+				if tt.reload != "" && i == tt.reloadAt {
+					// same trigger, followed by the reloaded part
+					td = parse(t, tt.definition[:strings.Index(tt.definition, "trap:")]+tt.reload)
+				}
+				sent = false
+				if len(ev.Deletes) > 0 {
+					a.deleteTriggerStates(td, "", ev)
+				}
+				a.handleTrapEvent(context.Background(), td, "", ev)
+				got = append(got, sent)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got traps %v, want %v", got, tt.want)
+			}
+			if n := len(a.triggerStates.states); n != tt.wantStates {
+				t.Errorf("got %d states, want %d", n, tt.wantStates)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmic/api"
+	"github.com/openconfig/gnmic/formatters"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	lastBootedPath          = "/system/information/last-booted"
+	bootTimeRefreshInterval = time.Minute
+)
+
+const (
+	// UptimeSourceSystem: sysUpTime.0 is the system uptime, read from the gNMI server.
+	UptimeSourceSystem = "system"
+	// UptimeSourceApp: sysUpTime.0 is the app uptime.
+	UptimeSourceApp = "app"
+)
+
+type bootTime struct {
+	m *sync.RWMutex
+	t time.Time
+}
+
+func (b *bootTime) get() time.Time {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	return b.t
+}
+
+func (b *bootTime) set(t time.Time) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.t = t
+}
+
+// watchBootTime reads the system boot time from the gNMI server
+// and refreshes it periodically.
+func (a *app) watchBootTime(ctx context.Context) {
+	ticker := time.NewTicker(bootTimeRefreshInterval)
+	defer ticker.Stop()
+	for {
+		t, err := a.getBootTime(ctx)
+		if err != nil {
+			log.Errorf("failed to get system boot time: %v", err)
+		} else if !t.Equal(a.bootTime.get()) {
+			log.Infof("system boot time: %s", t)
+			a.bootTime.set(t)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *app) getBootTime(ctx context.Context) (time.Time, error) {
+	req, err := api.NewGetRequest(
+		api.Path(lastBootedPath),
+		api.EncodingASCII(),
+	)
+	if err != nil {
+		return time.Time{}, err
+	}
+	rsp, err := a.tg.Get(ctx, req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	evs, err := formatters.GetResponseToEventMsgs(rsp, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, ev := range evs {
+		for _, v := range ev.Values {
+			s, ok := v.(string)
+			if !ok {
+				return time.Time{}, fmt.Errorf("unexpected %s value type %T", lastBootedPath, v)
+			}
+			return time.Parse(time.RFC3339Nano, s)
+		}
+	}
+	return time.Time{}, fmt.Errorf("no value returned for %s", lastBootedPath)
+}
+
+// sysUpTime returns the value of sysUpTime.0 in hundredths of a second.
+// It falls back to the app uptime until the system boot time is known.
+func (a *app) sysUpTime() uint32 {
+	if a.uptimeSource == UptimeSourceApp {
+		return timeTicks(time.Since(a.startTime))
+	}
+	bt := a.bootTime.get()
+	if bt.IsZero() {
+		log.Debugf("system boot time unknown, using app uptime")
+		return timeTicks(time.Since(a.startTime))
+	}
+	return timeTicks(time.Since(bt))
+}
+
+// timeTicks converts a duration to TimeTicks,
+// wrapping around at 2^32 as per RFC 2578.
+func timeTicks(d time.Duration) uint32 {
+	if d < 0 {
+		return 0
+	}
+	return uint32(uint64(d/(10*time.Millisecond)) % (1 << 32))
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeTicks(t *testing.T) {
+	const tick = 10 * time.Millisecond
+	// TimeTicks wrap around after 2^32 hundredths of a second, about 497 days
+	const wrap = (1 << 32) * tick
+	tests := []struct {
+		name string
+		d    time.Duration
+		want uint32
+	}{
+		{name: "zero", d: 0, want: 0},
+		{name: "negative", d: -time.Second, want: 0},
+		{name: "sub_tick", d: 9 * time.Millisecond, want: 0},
+		{name: "one_tick", d: tick, want: 1},
+		{name: "rounded_down", d: 19*time.Millisecond + 999*time.Microsecond, want: 1},
+		{name: "one_second", d: time.Second, want: 100},
+		{name: "one_day", d: 24 * time.Hour, want: 8640000},
+		{name: "just_under_wrap", d: wrap - tick, want: 1<<32 - 1},
+		{name: "just_under_wrap_sub_tick", d: wrap - time.Millisecond, want: 1<<32 - 1},
+		{name: "wrap", d: wrap, want: 0},
+		{name: "just_over_wrap", d: wrap + 5*tick, want: 5},
+		{name: "twice_wrap", d: 2*wrap + tick, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := timeTicks(tt.d); got != tt.want {
+				t.Errorf("timeTicks(%s) = %d, want %d", tt.d, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"fmt"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+func msgFlags(securityLevel string) (g.SnmpV3MsgFlags, error) {
+	switch securityLevel {
+	case "no-auth-no-priv":
+		return g.NoAuthNoPriv, nil
+	case "auth-no-priv":
+		return g.AuthNoPriv, nil
+	case "auth-priv", "":
+		return g.AuthPriv, nil
+	}
+	return 0, fmt.Errorf("unknown security-level %q", securityLevel)
+}
+
+func authProtocol(p string) (g.SnmpV3AuthProtocol, error) {
+	switch p {
+	case "md5":
+		return g.MD5, nil
+	case "sha", "":
+		return g.SHA, nil
+	case "sha-224":
+		return g.SHA224, nil
+	case "sha-256":
+		return g.SHA256, nil
+	case "sha-384":
+		return g.SHA384, nil
+	case "sha-512":
+		return g.SHA512, nil
+	}
+	return 0, fmt.Errorf("unknown authentication-protocol %q", p)
+}
+
+func privProtocol(p string) (g.SnmpV3PrivProtocol, error) {
+	switch p {
+	case "des":
+		return g.DES, nil
+	case "aes", "":
+		return g.AES, nil
+	case "aes-192":
+		return g.AES192, nil
+	case "aes-256":
+		return g.AES256, nil
+	case "aes-192-c":
+		return g.AES192C, nil
+	case "aes-256-c":
+		return g.AES256C, nil
+	}
+	return 0, fmt.Errorf("unknown privacy-protocol %q", p)
+}
+
+// validateUSM checks that the destination SNMPv3 parameters
+// are consistent with its security level.
+func (d *snmpTrapDestination) validateUSM() error {
+	if d.Version != "v3" {
+		return nil
+	}
+	if d.UserName == "" {
+		return fmt.Errorf("destination %q: missing user-name", d.Address)
+	}
+	flags, err := msgFlags(d.SecurityLevel)
+	if err != nil {
+		return err
+	}
+	if flags&g.AuthNoPriv != 0 {
+		if _, err = authProtocol(d.AuthProtocol); err != nil {
+			return err
+		}
+		if d.AuthKey == "" {
+			return fmt.Errorf("destination %q: missing authentication-key", d.Address)
+		}
+	}
+	if flags&g.AuthPriv == g.AuthPriv {
+		if _, err = privProtocol(d.PrivProtocol); err != nil {
+			return err
+		}
+		if d.PrivKey == "" {
+			return fmt.Errorf("destination %q: missing privacy-key", d.Address)
+		}
+	}
+	return nil
+}
+
+// usmParams builds the USM security parameters of an SNMPv3 destination.
+// Notifications sent as traps use the local engine as the authoritative engine,
+// informs leave it empty so that the receiver engine is discovered.
+func (d *snmpTrapDestination) usmParams(engineID string, engineBoots, engineTime uint32, inform bool) (g.SnmpV3MsgFlags, *g.UsmSecurityParameters, error) {
+	flags, err := msgFlags(d.SecurityLevel)
+	if err != nil {
+		return 0, nil, err
+	}
+	sp := &g.UsmSecurityParameters{
+		UserName:               d.UserName,
+		AuthenticationProtocol: g.NoAuth,
+		PrivacyProtocol:        g.NoPriv,
+	}
+	if !inform {
+		sp.AuthoritativeEngineID = engineID
+		sp.AuthoritativeEngineBoots = engineBoots
+		sp.AuthoritativeEngineTime = engineTime
+	}
+	if flags&g.AuthNoPriv != 0 {
+		sp.AuthenticationProtocol, err = authProtocol(d.AuthProtocol)
+		if err != nil {
+			return 0, nil, err
+		}
+		sp.AuthenticationPassphrase = d.AuthKey
+	}
+	if flags&g.AuthPriv == g.AuthPriv {
+		sp.PrivacyProtocol, err = privProtocol(d.PrivProtocol)
+		if err != nil {
+			return 0, nil, err
+		}
+		sp.PrivacyPassphrase = d.PrivKey
+	}
+	return flags, sp, nil
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+func TestValidateUSM(t *testing.T) {
+	tests := []struct {
+		name string
+		dest *snmpTrapDestination
+		// error substring, empty for no error
+		wantErr string
+	}{
+		{
+			name: "not_v3",
+			dest: &snmpTrapDestination{Version: "v2c"},
+		},
+		{
+			name:    "missing_user_name",
+			dest:    &snmpTrapDestination{Version: "v3", SecurityLevel: "no-auth-no-priv"},
+			wantErr: "missing user-name",
+		},
+		{
+			name: "no_auth_no_priv",
+			dest: &snmpTrapDestination{Version: "v3", SecurityLevel: "no-auth-no-priv", UserName: "u"},
+		},
+		{
+			name:    "unknown_security_level",
+			dest:    &snmpTrapDestination{Version: "v3", SecurityLevel: "priv", UserName: "u"},
+			wantErr: `unknown security-level "priv"`,
+		},
+		{
+			name: "auth_no_priv",
+			dest: &snmpTrapDestination{Version: "v3", SecurityLevel: "auth-no-priv", UserName: "u", AuthProtocol: "sha-256", AuthKey: "authkey1"},
+		},
+		{
+			name:    "auth_no_priv_missing_auth_key",
+			dest:    &snmpTrapDestination{Version: "v3", SecurityLevel: "auth-no-priv", UserName: "u"},
+			wantErr: "missing authentication-key",
+		},
+		{
+			name:    "unknown_auth_protocol",
+			dest:    &snmpTrapDestination{Version: "v3", SecurityLevel: "auth-no-priv", UserName: "u", AuthProtocol: "sha-1", AuthKey: "authkey1"},
+			wantErr: `unknown authentication-protocol "sha-1"`,
+		},
+		{
+			name: "auth_no_priv_ignores_priv_key",
+			dest: &snmpTrapDestination{Version: "v3", SecurityLevel: "auth-no-priv", UserName: "u", AuthKey: "authkey1", PrivProtocol: "bad"},
+		},
+		{
+			name: "auth_priv",
+			dest: &snmpTrapDestination{Version: "v3", SecurityLevel: "auth-priv", UserName: "u", AuthKey: "authkey1", PrivProtocol: "aes-256-c", PrivKey: "privkey1"},
+		},
+		{
+			name:    "default_auth_priv_missing_priv_key",
+			dest:    &snmpTrapDestination{Version: "v3", UserName: "u", AuthKey: "authkey1"},
+			wantErr: "missing privacy-key",
+		},
+		{
+			name:    "auth_priv_missing_auth_key",
+			dest:    &snmpTrapDestination{Version: "v3", SecurityLevel: "auth-priv", UserName: "u", PrivKey: "privkey1"},
+			wantErr: "missing authentication-key",
+		},
+		{
+			name:    "unknown_priv_protocol",
+			dest:    &snmpTrapDestination{Version: "v3", SecurityLevel: "auth-priv", UserName: "u", AuthKey: "authkey1", PrivProtocol: "3des", PrivKey: "privkey1"},
+			wantErr: `unknown privacy-protocol "3des"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.dest.validateUSM()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUSMParams(t *testing.T) {
+	const engineID = "\x80\x00\x00\x00\x04test"
+	tests := []struct {
+		name      string
+		dest      *snmpTrapDestination
+		inform    bool
+		wantFlags g.SnmpV3MsgFlags
+		wantSP    *g.UsmSecurityParameters
+		wantErr   bool
+	}{
+		{
+			name:      "no_auth_no_priv_trap",
+			dest:      &snmpTrapDestination{SecurityLevel: "no-auth-no-priv", UserName: "u", AuthKey: "ignored"},
+			wantFlags: g.NoAuthNoPriv,
+			wantSP: &g.UsmSecurityParameters{
+				UserName:                 "u",
+				AuthoritativeEngineID:    engineID,
+				AuthoritativeEngineBoots: 2,
+				AuthoritativeEngineTime:  100,
+				AuthenticationProtocol:   g.NoAuth,
+				PrivacyProtocol:          g.NoPriv,
+			},
+		},
+		{
+			name:      "auth_no_priv_default_protocol",
+			dest:      &snmpTrapDestination{SecurityLevel: "auth-no-priv", UserName: "u", AuthKey: "authkey1"},
+			wantFlags: g.AuthNoPriv,
+			wantSP: &g.UsmSecurityParameters{
+				UserName:                 "u",
+				AuthoritativeEngineID:    engineID,
+				AuthoritativeEngineBoots: 2,
+				AuthoritativeEngineTime:  100,
+				AuthenticationProtocol:   g.SHA,
+				AuthenticationPassphrase: "authkey1",
+				PrivacyProtocol:          g.NoPriv,
+			},
+		},
+		{
+			name:      "auth_priv_inform",
+			dest:      &snmpTrapDestination{UserName: "u", AuthProtocol: "md5", AuthKey: "authkey1", PrivProtocol: "des", PrivKey: "privkey1"},
+			inform:    true,
+			wantFlags: g.AuthPriv,
+			// the receiver engine is discovered
+			wantSP: &g.UsmSecurityParameters{
+				UserName:                 "u",
+				AuthenticationProtocol:   g.MD5,
+				AuthenticationPassphrase: "authkey1",
+				PrivacyProtocol:          g.DES,
+				PrivacyPassphrase:        "privkey1",
+			},
+		},
+		{
+			name:    "unknown_security_level",
+			dest:    &snmpTrapDestination{SecurityLevel: "none", UserName: "u"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown_priv_protocol",
+			dest:    &snmpTrapDestination{UserName: "u", AuthKey: "authkey1", PrivProtocol: "aes-128", PrivKey: "privkey1"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, sp, err := tt.dest.usmParams(engineID, 2, 100, tt.inform)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if flags != tt.wantFlags {
+				t.Errorf("got flags %v, want %v", flags, tt.wantFlags)
+			}
+			if !reflect.DeepEqual(sp, tt.wantSP) {
+				t.Errorf("got security parameters %+v, want %+v", sp, tt.wantSP)
+			}
+		})
+	}
+}
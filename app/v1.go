@@ -0,0 +1,94 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+const (
+	// snmpTraps, the prefix of the standard notifications (RFC 3418)
+	snmpTrapsOID          = "1.3.6.1.6.3.1.1.5"
+	snmpTrapEnterpriseOID = "1.3.6.1.6.3.1.1.4.3.0"
+	// SNMPv1 generic-trap enterpriseSpecific
+	genericTrapEnterpriseSpecific = 6
+)
+
+// toV1Trap translates an SNMPv2 notification into an SNMPv1 Trap-PDU
+// as per RFC 3584 section 3.2.
+// localAddr is used as agent-addr unless the notification carries snmpTrapAddress.0.
+func toV1Trap(trap g.SnmpTrap, localAddr net.Addr) (g.SnmpTrap, error) {
+	if len(trap.Variables) < 2 ||
+		strings.TrimPrefix(trap.Variables[0].Name, ".") != sysUpTimeInstanceOID ||
+		strings.TrimPrefix(trap.Variables[1].Name, ".") != snmpTrapOID {
+		return g.SnmpTrap{}, fmt.Errorf("notification does not start with sysUpTime.0 and snmpTrapOID.0")
+	}
+	uptime, ok := trap.Variables[0].Value.(uint32)
+	if !ok {
+		return g.SnmpTrap{}, fmt.Errorf("unexpected sysUpTime.0 value type %T", trap.Variables[0].Value)
+	}
+	oid, ok := trap.Variables[1].Value.(string)
+	if !ok {
+		return g.SnmpTrap{}, fmt.Errorf("unexpected snmpTrapOID.0 value type %T", trap.Variables[1].Value)
+	}
+	oid = strings.TrimPrefix(oid, ".")
+	subIDs := strings.Split(oid, ".")
+	if len(subIDs) < 2 {
+		return g.SnmpTrap{}, fmt.Errorf("invalid snmpTrapOID.0 value %q", oid)
+	}
+	lastSubID, err := strconv.Atoi(subIDs[len(subIDs)-1])
+	if err != nil {
+		return g.SnmpTrap{}, fmt.Errorf("invalid snmpTrapOID.0 value %q: %v", oid, err)
+	}
+
+	v1Trap := g.SnmpTrap{
+		Timestamp:    uint(uptime),
+		AgentAddress: "0.0.0.0",
+		Variables:    make([]g.SnmpPDU, 0, len(trap.Variables)-2),
+	}
+	if udpAddr, ok := localAddr.(*net.UDPAddr); ok && udpAddr.IP.To4() != nil {
+		v1Trap.AgentAddress = udpAddr.IP.To4().String()
+	}
+	var enterprise string
+	for _, v := range trap.Variables[2:] {
+		switch strings.TrimPrefix(v.Name, ".") {
+		case snmpTrapAddressOID:
+			if addr, ok := v.Value.(string); ok {
+				v1Trap.AgentAddress = addr
+			}
+		case snmpTrapEnterpriseOID:
+			if e, ok := v.Value.(string); ok {
+				enterprise = strings.TrimPrefix(e, ".")
+			}
+		}
+		// Counter64 has no SNMPv1 equivalent
+		if v.Type == g.Counter64 {
+			continue
+		}
+		v1Trap.Variables = append(v1Trap.Variables, v)
+	}
+
+	// standard notifications: coldStart, warmStart, linkDown, linkUp,
+	// authenticationFailure and egpNeighborLoss
+	prefix := strings.Join(subIDs[:len(subIDs)-1], ".")
+	if prefix == snmpTrapsOID && lastSubID >= 1 && lastSubID <= 6 {
+		v1Trap.GenericTrap = lastSubID - 1
+		v1Trap.SpecificTrap = 0
+		v1Trap.Enterprise = enterprise
+		if v1Trap.Enterprise == "" {
+			v1Trap.Enterprise = snmpTrapsOID
+		}
+		return v1Trap, nil
+	}
+	// enterprise specific notifications
+	v1Trap.GenericTrap = genericTrapEnterpriseSpecific
+	v1Trap.SpecificTrap = lastSubID
+	v1Trap.Enterprise = prefix
+	if len(subIDs) > 2 && subIDs[len(subIDs)-2] == "0" {
+		v1Trap.Enterprise = strings.Join(subIDs[:len(subIDs)-2], ".")
+	}
+	return v1Trap, nil
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+func TestToV1Trap(t *testing.T) {
+	uptime := g.SnmpPDU{Name: "." + sysUpTimeInstanceOID, Type: g.TimeTicks, Value: uint32(1234)}
+	trapOID := func(oid string) g.SnmpPDU {
+		return g.SnmpPDU{Name: "." + snmpTrapOID, Type: g.ObjectIdentifier, Value: oid}
+	}
+	ifIndex := g.SnmpPDU{Name: ".1.3.6.1.2.1.2.2.1.1.1", Type: g.Integer, Value: 1}
+	counter64 := g.SnmpPDU{Name: ".1.3.6.1.2.1.31.1.1.1.6.1", Type: g.Counter64, Value: uint64(42)}
+	trapAddress := g.SnmpPDU{Name: "." + snmpTrapAddressOID, Type: g.IPAddress, Value: "192.0.2.1"}
+	enterprise := g.SnmpPDU{Name: "." + snmpTrapEnterpriseOID, Type: g.ObjectIdentifier, Value: ".1.3.6.1.4.1.6527"}
+	local := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 40000}
+
+	tests := []struct {
+		name    string
+		vars    []g.SnmpPDU
+		local   net.Addr
+		want    g.SnmpTrap
+		wantErr bool
+	}{
+		{
+			name:  "linkDown",
+			vars:  []g.SnmpPDU{uptime, trapOID(".1.3.6.1.6.3.1.1.5.3"), ifIndex},
+			local: local,
+			want: g.SnmpTrap{
+				Enterprise:   snmpTrapsOID,
+				AgentAddress: "10.0.0.1",
+				GenericTrap:  2,
+				SpecificTrap: 0,
+				Timestamp:    1234,
+				Variables:    []g.SnmpPDU{ifIndex},
+			},
+		},
+		{
+			name:  "coldStart_with_snmpTrapEnterprise",
+			vars:  []g.SnmpPDU{uptime, trapOID("1.3.6.1.6.3.1.1.5.1"), enterprise},
+			local: local,
+			want: g.SnmpTrap{
+				Enterprise:   "1.3.6.1.4.1.6527",
+				AgentAddress: "10.0.0.1",
+				GenericTrap:  0,
+				Timestamp:    1234,
+				Variables:    []g.SnmpPDU{enterprise},
+			},
+		},
+		{
+			name:  "enterprise_specific_with_zero_sub_identifier",
+			vars:  []g.SnmpPDU{uptime, trapOID("1.3.6.1.4.1.6527.0.7"), ifIndex},
+			local: local,
+			want: g.SnmpTrap{
+				Enterprise:   "1.3.6.1.4.1.6527",
+				AgentAddress: "10.0.0.1",
+				GenericTrap:  genericTrapEnterpriseSpecific,
+				SpecificTrap: 7,
+				Timestamp:    1234,
+				Variables:    []g.SnmpPDU{ifIndex},
+			},
+		},
+		{
+			name:  "enterprise_specific_without_zero_sub_identifier",
+			vars:  []g.SnmpPDU{uptime, trapOID("1.3.6.1.4.1.6527.3.1"), ifIndex},
+			local: local,
+			want: g.SnmpTrap{
+				Enterprise:   "1.3.6.1.4.1.6527.3",
+				AgentAddress: "10.0.0.1",
+				GenericTrap:  genericTrapEnterpriseSpecific,
+				SpecificTrap: 1,
+				Timestamp:    1234,
+				Variables:    []g.SnmpPDU{ifIndex},
+			},
+		},
+		{
+			name:  "counter64_dropped",
+			vars:  []g.SnmpPDU{uptime, trapOID("1.3.6.1.4.1.6527.0.7"), ifIndex, counter64},
+			local: local,
+			want: g.SnmpTrap{
+				Enterprise:   "1.3.6.1.4.1.6527",
+				AgentAddress: "10.0.0.1",
+				GenericTrap:  genericTrapEnterpriseSpecific,
+				SpecificTrap: 7,
+				Timestamp:    1234,
+				Variables:    []g.SnmpPDU{ifIndex},
+			},
+		},
+		{
+			name:  "agent_address_from_snmpTrapAddress",
+			vars:  []g.SnmpPDU{uptime, trapOID("1.3.6.1.4.1.6527.0.7"), trapAddress},
+			local: local,
+			want: g.SnmpTrap{
+				Enterprise:   "1.3.6.1.4.1.6527",
+				AgentAddress: "192.0.2.1",
+				GenericTrap:  genericTrapEnterpriseSpecific,
+				SpecificTrap: 7,
+				Timestamp:    1234,
+				Variables:    []g.SnmpPDU{trapAddress},
+			},
+		},
+		{
+			name:  "ipv6_local_address",
+			vars:  []g.SnmpPDU{uptime, trapOID("1.3.6.1.4.1.6527.0.7")},
+			local: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000},
+			want: g.SnmpTrap{
+				Enterprise:   "1.3.6.1.4.1.6527",
+				AgentAddress: "0.0.0.0",
+				GenericTrap:  genericTrapEnterpriseSpecific,
+				SpecificTrap: 7,
+				Timestamp:    1234,
+				Variables:    []g.SnmpPDU{},
+			},
+		},
+		{
+			name:    "missing_snmpTrapOID",
+			vars:    []g.SnmpPDU{uptime},
+			local:   local,
+			wantErr: true,
+		},
+		{
+			name:    "wrong_order",
+			vars:    []g.SnmpPDU{trapOID("1.3.6.1.4.1.6527.0.7"), uptime},
+			local:   local,
+			wantErr: true,
+		},
+		{
+			name: "unexpected_sysUpTime_type",
+			vars: []g.SnmpPDU{
+				{Name: "." + sysUpTimeInstanceOID, Type: g.TimeTicks, Value: 1234},
+				trapOID("1.3.6.1.4.1.6527.0.7"),
+			},
+			local:   local,
+			wantErr: true,
+		},
+		{
+			name:    "invalid_snmpTrapOID",
+			vars:    []g.SnmpPDU{uptime, trapOID("1.3.6.1.4.1.x")},
+			local:   local,
+			wantErr: true,
+		},
+		{
+			name:    "short_snmpTrapOID",
+			vars:    []g.SnmpPDU{uptime, trapOID("1")},
+			local:   local,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toV1Trap(g.SnmpTrap{Variables: tt.vars}, tt.local)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toV1Trap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toV1Trap() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
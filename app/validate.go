@@ -0,0 +1,223 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// jq string literal
+	jqStringRegex = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+	// a string that looks like an OID, possibly malformed
+	oidLikeRegex = regexp.MustCompile(`^\.?[0-9]+\.[0-9.]*$`)
+	// undefined variable in a jq compilation error
+	jqUndefinedVarRegex = regexp.MustCompile(`variable not defined: (\$[A-Za-z_][A-Za-z0-9_]*)`)
+	// line number in a YAML parser error
+	yamlLineRegex = regexp.MustCompile(`line ([0-9]+)`)
+)
+
+// definitionError is a trap definition validation error,
+// located in its file.
+type definitionError struct {
+	file string
+	line int
+	msg  string
+}
+
+func (e *definitionError) Error() string {
+	if e.line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.file, e.line, e.msg)
+	}
+	return fmt.Sprintf("%s: %s", e.file, e.msg)
+}
+
+// ValidateTrapDefinitions loads the trap definition files under dir the same way
+// the app does, without connecting to the NDK or the gNMI server,
+// and runs extra checks on the loaded definitions.
+// It returns the errors found, sorted by file and line.
+// The returned error is only set if dir can't be walked.
+func ValidateTrapDefinitions(dir string) ([]error, error) {
+	traps, fileErrs, err := readTrapsDefinition(dir)
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]*definitionError, 0, len(fileErrs))
+	for path, err := range fileErrs {
+		errs = append(errs, locateError(path, err))
+	}
+	if _, _, _, err := readGlobals(dir); err != nil {
+		errs = append(errs, &definitionError{file: dir, msg: err.Error()})
+	}
+	for _, t := range traps {
+		v, err := newValidator(t.file)
+		if err != nil {
+			errs = append(errs, &definitionError{file: t.file, msg: err.Error()})
+			continue
+		}
+		v.checkDefinition(t)
+		errs = append(errs, v.errs...)
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].file != errs[j].file {
+			return errs[i].file < errs[j].file
+		}
+		return errs[i].line < errs[j].line
+	})
+	rs := make([]error, 0, len(errs))
+	for _, e := range errs {
+		rs = append(rs, e)
+	}
+	return rs, nil
+}
+
+// locateError returns the load error err of the file path
+// with the line of the value that caused it, if it can be found.
+func locateError(path string, err error) *definitionError {
+	var ve *valueError
+	if !errors.As(err, &ve) {
+		// YAML errors hold their line
+		return yamlError(path, err)
+	}
+	e := &definitionError{file: path, msg: err.Error()}
+	v, verr := newValidator(path)
+	if verr != nil {
+		return e
+	}
+	e.line = v.valueLine(ve)
+	return e
+}
+
+func yamlError(path string, err error) *definitionError {
+	e := &definitionError{file: path, msg: err.Error()}
+	if m := yamlLineRegex.FindStringSubmatch(err.Error()); m != nil {
+		e.line, _ = strconv.Atoi(m[1])
+	}
+	return e
+}
+
+// validator checks a trap definition beyond what its compilation checks.
+// The YAML parser does not keep the nodes position, the lines reported are
+// found by searching the file for the faulty key and value.
+type validator struct {
+	file  string
+	lines []string
+	errs  []*definitionError
+	// number of lookups per key and value,
+	// repeated lookups return the next matching line
+	lookups map[string]int
+}
+
+func newValidator(path string) (*validator, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &validator{
+		file:    path,
+		lines:   strings.Split(string(b), "\n"),
+		errs:    make([]*definitionError, 0),
+		lookups: make(map[string]int),
+	}, nil
+}
+
+func (v *validator) errorf(line int, format string, args ...any) {
+	v.errs = append(v.errs, &definitionError{
+		file: v.file,
+		line: line,
+		msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) checkDefinition(t *trapDefinition) {
+	tp := t.TrapPDU
+	if oid := strings.TrimSpace(tp.OID); oidLikeRegex.MatchString(oid) && !isOID(oid) {
+		v.errorf(v.lineOf("oid", oid), "trap oid: malformed OID %q", oid)
+	} else if !isOID(oid) {
+		v.checkOIDLiterals("trap oid", tp.OID)
+	}
+	for idx, b := range tp.Bindings {
+		where := fmt.Sprintf("binding index %d", idx)
+		if b.library != "" {
+			where += fmt.Sprintf(" from library %q", b.library)
+		}
+		v.checkOIDLiterals(where+" oid", b.OID)
+	}
+}
+
+// valueLine returns the line of the value that caused the error ve,
+// 0 if the value is not found, e.g. a value from a library.
+// The errors of multi-line jq expressions are reported at the faulty line.
+func (v *validator) valueLine(ve *valueError) int {
+	line := v.lineOf(ve.key, ve.value)
+	if line == 0 || ve.key != "" {
+		return line
+	}
+	offset := -1
+	var te interface{ Token() (string, int) }
+	if errors.As(ve.err, &te) {
+		_, offset = te.Token()
+	} else if m := jqUndefinedVarRegex.FindStringSubmatch(ve.err.Error()); m != nil {
+		offset = strings.Index(ve.value, m[1])
+	}
+	if offset > 0 && offset <= len(ve.value) {
+		line += strings.Count(ve.value[:offset], "\n")
+	}
+	return line
+}
+
+// checkOIDLiterals checks that the string literals that look like
+// an OID in the jq expression code are well-formed.
+// A trailing dot is allowed, for a literal followed by an index.
+func (v *validator) checkOIDLiterals(where, code string) {
+	for _, m := range jqStringRegex.FindAllStringSubmatch(code, -1) {
+		s := m[1]
+		if !oidLikeRegex.MatchString(s) {
+			continue
+		}
+		if isOID(strings.TrimSuffix(s, ".")) {
+			continue
+		}
+		v.errorf(v.lineOf("", m[0]), "%s: malformed OID %q", where, s)
+	}
+}
+
+// lineOf returns the line of the file with the key followed by the value,
+// or only containing the value if key is empty.
+// The nth lookup of the same key and value returns the nth matching line.
+// It returns 0 if no such line is found.
+func (v *validator) lineOf(key, value string) int {
+	value = strings.TrimSpace(strings.SplitN(value, "\n", 2)[0])
+	lk := key + ":" + value
+	skip := v.lookups[lk]
+	v.lookups[lk]++
+	first := 0
+	for i, l := range v.lines {
+		l = strings.TrimSpace(l)
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		if key != "" {
+			l = strings.TrimSpace(strings.TrimPrefix(l, "-"))
+			if !strings.HasPrefix(l, key+":") {
+				continue
+			}
+			l = l[len(key)+1:]
+		}
+		if !strings.Contains(l, value) {
+			continue
+		}
+		if first == 0 {
+			first = i + 1
+		}
+		if skip == 0 {
+			return i + 1
+		}
+		skip--
+	}
+	return first
+}
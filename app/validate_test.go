@@ -0,0 +1,165 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateTrapDefinitions(t *testing.T) {
+	type wantErr struct {
+		line int
+		// message substring
+		msg string
+	}
+	const header = `name: test
+trigger:
+  path: /interface[name=*]/oper-state
+  publish:
+    - if_name: $keys.interface_name
+`
+	tests := []struct {
+		name       string
+		definition string
+		wantErrs   []wantErr
+	}{
+		{
+			name: "valid",
+			definition: header + `trap:
+  oid: 1.3.6.1.6.3.1.1.5.3
+  bindings:
+    - oid: '".1.3.6.1.2.1.2.2.1.2"'
+      type: octetString
+      value: $if_name
+`,
+		},
+		{
+			name: "function_parameter",
+			definition: header + `trap:
+  oid: 1.3.6.1.6.3.1.1.5.3
+  bindings:
+    - oid: '".1.3.6.1.2.1.2.2.1.2"'
+      type: octetString
+      value: 'def f($a): $a; f($if_name)'
+`,
+		},
+		{
+			name: "binding_variable",
+			definition: header + `trap:
+  oid: 1.3.6.1.6.3.1.1.5.3
+  bindings:
+    - oid: '".1.3.6.1.2.1.2.2.1.2"'
+      type: octetString
+      value: '$if_name as $n | $n'
+`,
+		},
+		{
+			name: "undefined_variable",
+			definition: header + `trap:
+  oid: 1.3.6.1.6.3.1.1.5.3
+  bindings:
+    - oid: '".1.3.6.1.2.1.2.2.1.2"'
+      type: octetString
+      value: $ifname
+`,
+			wantErrs: []wantErr{{line: 11, msg: "variable not defined: $ifname"}},
+		},
+		{
+			name: "undefined_variable_multi_line",
+			definition: header + `trap:
+  oid: |
+    if $oper_state == 1
+    then "1.3.6.1.6.3.1.1.5.4"
+    else "1.3.6.1.6.3.1.1.5.3"
+    end
+  bindings:
+    - oid: '".1.3.6.1.2.1.2.2.1.2"'
+      type: octetString
+      value: $if_name
+`,
+			wantErrs: []wantErr{{line: 8, msg: "variable not defined: $oper_state"}},
+		},
+		{
+			name: "jq_syntax_error",
+			definition: header + `trap:
+  oid: 1.3.6.1.6.3.1.1.5.3
+  bindings:
+    - oid: '".1.3.6.1.2.1.2.2.1.2"'
+      type: octetString
+      value: '$if_name |'
+`,
+			wantErrs: []wantErr{{line: 11, msg: "unexpected EOF"}},
+		},
+		{
+			name: "unknown_type",
+			definition: header + `trap:
+  oid: 1.3.6.1.6.3.1.1.5.3
+  bindings:
+    - oid: '".1.3.6.1.2.1.2.2.1.2"'
+      type: string
+      value: $if_name
+`,
+			wantErrs: []wantErr{{line: 10, msg: `unknown type "string"`}},
+		},
+		{
+			name: "malformed_oid",
+			definition: header + `trap:
+  oid: 1.3.6.1.6.3.1.1.5.3
+  bindings:
+    - oid: '".1.3.6..1.2.1.2.2.1.2"'
+      type: octetString
+      value: $if_name
+`,
+			wantErrs: []wantErr{{line: 9, msg: `binding index 0 oid: malformed OID ".1.3.6..1.2.1.2.2.1.2"`}},
+		},
+		{
+			name: "threshold_without_sample_mode",
+			definition: `name: test
+trigger:
+  path: /platform/control[slot=*]/cpu[index=all]/total/instant
+  mode: on-change
+  threshold:
+    rising: 85
+    falling: 70
+trap:
+  oid: 1.3.6.1.2.1.16.0.1
+  bindings:
+    - oid: '".1.3.6.1.2.1.16.3.1.1.5.1"'
+      type: int
+      value: $threshold.value
+`,
+			wantErrs: []wantErr{{line: 4, msg: `threshold is only valid with mode "sample"`}},
+		},
+		{
+			name:       "yaml_error",
+			definition: header + "trap: [\n",
+			wantErrs:   []wantErr{{line: 6, msg: "yaml:"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "test.yaml")
+			err := os.WriteFile(path, []byte(tt.definition), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs, err := ValidateTrapDefinitions(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(errs) != len(tt.wantErrs) {
+				t.Fatalf("got errors %v, want %v", errs, tt.wantErrs)
+			}
+			for i, err := range errs {
+				want := tt.wantErrs[i]
+				prefix := fmt.Sprintf("%s:%d: ", path, want.line)
+				if !strings.HasPrefix(err.Error(), prefix) || !strings.Contains(err.Error(), want.msg) {
+					t.Errorf("got error %q, want line %d and %q", err, want.line, want.msg)
+				}
+			}
+		})
+	}
+}
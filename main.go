@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"os"
 	"time"
 
 	agent "github.com/karimra/srl-ndk-demo"
@@ -20,7 +21,19 @@ const (
 var version = "dev"
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(validate(os.Args[2:]))
+		case "simulate":
+			os.Exit(simulate(os.Args[2:]))
+		case "test":
+			os.Exit(test(os.Args[2:]))
+		}
+	}
 	trapDir := flag.String("trap-dir", "/opt/snmp-traps/traps", "directory containing trap definition files")
+	uptimeSource := flag.String("uptime-source", app.UptimeSourceSystem,
+		fmt.Sprintf("sysUpTime.0 source, one of %q or %q", app.UptimeSourceSystem, app.UptimeSourceApp))
 	debug := flag.Bool("d", false, "turn on debug")
 	versionFlag := flag.Bool("v", false, "print version")
 	flag.Parse()
@@ -29,6 +42,11 @@ func main() {
 		fmt.Println(version)
 		return
 	}
+	switch *uptimeSource {
+	case app.UptimeSourceSystem, app.UptimeSourceApp:
+	default:
+		log.Fatalf("unknown uptime source %q", *uptimeSource)
+	}
 	if *debug {
 		log.SetLevel(log.DebugLevel)
 		log.SetReportCaller(true)
@@ -50,8 +68,79 @@ CRAGENT:
 	trapApp := app.New(
 		app.WithAgent(agt),
 		app.WithDebug(*debug),
-		app.WithTrapDir(*trapDir))
+		app.WithTrapDir(*trapDir),
+		app.WithUptimeSource(*uptimeSource))
 
 	log.Infof("starting App config handler...")
 	trapApp.Run(ctx)
 }
+
+// validate checks the trap definition files offline,
+// it returns the process exit code.
+func validate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	trapDir := fs.String("trap-dir", "/opt/snmp-traps/traps", "directory containing trap definition files")
+	fs.Parse(args)
+
+	// only report the validation errors
+	log.SetLevel(log.FatalLevel)
+	errs, err := app.ValidateTrapDefinitions(*trapDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read trap definitions directory %q: %v\n", *trapDir, err)
+		return 2
+	}
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Printf("trap definitions in %q are valid\n", *trapDir)
+	return 0
+}
+
+// simulate runs recorded gNMI notifications through a trap definition
+// and prints the resulting trap PDUs, it returns the process exit code.
+func simulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	defFile := fs.String("definition", "", "trap definition file")
+	rspFile := fs.String("responses", "", "file of gNMI SubscribeResponses, JSON or prototext separated by \"---\" lines")
+	fixturesFile := fs.String("fixtures", "", "YAML file mapping the tasks gNMI paths to their values")
+	debug := fs.Bool("d", false, "turn on debug")
+	fs.Parse(args)
+
+	if *defFile == "" || *rspFile == "" {
+		fmt.Fprintln(os.Stderr, "--definition and --responses are required")
+		fs.Usage()
+		return 2
+	}
+	log.SetLevel(log.FatalLevel)
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	}
+	err := app.Simulate(context.Background(), *defFile, *rspFile, *fixturesFile, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// test runs the test cases of the trap definitions,
+// it returns the process exit code.
+func test(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	trapDir := fs.String("trap-dir", "/opt/snmp-traps/traps", "directory containing trap definition files")
+	fs.Parse(args)
+
+	log.SetLevel(log.FatalLevel)
+	ok, err := app.RunTests(context.Background(), *trapDir, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read trap definitions directory %q: %v\n", *trapDir, err)
+		return 2
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}